@@ -1,5 +1,12 @@
 package gochado
 
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "github.com/jmoiron/sqlx"
+)
+
 // Interface for making a chado loader from staging tables
 type ChadoLoader interface {
     // To prepare involved chado tables for bulk load, such as
@@ -13,3 +20,83 @@ type ChadoLoader interface {
     // data from staging tables.
     BulkLoad()
 }
+
+// Interface for loaders that could check their sql statements against the
+// target database before loading any data
+type Validator interface {
+    // Reports all the invalid sql statements of the loader
+    Validate() error
+}
+
+// Interface for loaders that could run their sql statements within a
+// transaction started elsewhere
+type TxLoader interface {
+    // Runs the statements within the transaction instead of the database
+    // handle, a nil value switches back to the database handle. The loader
+    // never commits or rollbacks the transaction.
+    SetTx(*sqlx.Tx)
+}
+
+// Interface for loaders whose database operations could be cancelled through
+// a context
+type ContextLoader interface {
+    // Same as BulkLoad, however aborts on cancellation of the context, rolls
+    // back its own transaction and returns the error
+    BulkLoadContext(ctx context.Context) error
+}
+
+// Interface for staging loaders whose database operations could be cancelled
+// through a context
+type ContextStagingLoader interface {
+    ContextLoader
+    CreateTablesContext(ctx context.Context) error
+    DropTablesContext(ctx context.Context) error
+}
+
+// Interface for chado loaders that could report the changes they would make
+// without modifying the database
+type DryRunner interface {
+    // Runs the staging loader and then its own bulk load in a transaction
+    // that is always rolled back. The staging loader is expected to have its
+    // data rows already added and has to implement TxLoader. Collects up
+    // to sample rows for each of the target.
+    DryRun(staging StagingLoader, sample int) (*DryRunReport, error)
+}
+
+// Projected changes of a dry run
+type DryRunReport struct {
+    // Names of target, either a table or table:type, in the order they are
+    // loaded
+    Targets []string
+    // Number of rows that would be inserted keyed by target
+    Counts map[string]int64
+    // Sample of rows that would be inserted keyed by target, each row is
+    // keyed by column names
+    Samples map[string][]map[string]interface{}
+}
+
+func NewDryRunReport() *DryRunReport {
+    return &DryRunReport{
+        Targets: make([]string, 0),
+        Counts:  make(map[string]int64),
+        Samples: make(map[string][]map[string]interface{}),
+    }
+}
+
+// Adds the count and sample rows of a target
+func (r *DryRunReport) Add(target string, count int64, samples []map[string]interface{}) {
+    if _, ok := r.Counts[target]; !ok {
+        r.Targets = append(r.Targets, target)
+    }
+    r.Counts[target] += count
+    r.Samples[target] = append(r.Samples[target], samples...)
+}
+
+// Summary of the counts, one target per line
+func (r *DryRunReport) String() string {
+    var b bytes.Buffer
+    for _, t := range r.Targets {
+        fmt.Fprintf(&b, "%s\t%d\n", t, r.Counts[t])
+    }
+    return b.String()
+}
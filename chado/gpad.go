@@ -1,9 +1,13 @@
 package chado
 
 import (
+    "context"
+    "errors"
+    "fmt"
     "github.com/dictybase/gochado"
     "github.com/jmoiron/sqlx"
-    "log"
+    "strings"
+    "time"
 )
 
 // Sqlite backend for loading GPAD data from staging to chado tables
@@ -14,11 +18,87 @@ type Sqlite struct {
     dbh *sqlx.DB
     // instance of Organism, should have genus and species defined
     *gochado.Organism
+    // transaction for running the statements instead of dbh
+    tx *sqlx.Tx
+    // records the provenance of load unless nil
+    provenance *gochado.Provenance
+    load       *gochado.Load
+    // receives the progress of loading
+    observer gochado.Observer
+    logger   gochado.Logger
+    // feature type that the ids are resolved within and the resolver of
+    // last load
+    ftype    string
+    resolver *gochado.FeatureResolver
 }
 
-// Create new instatnce of Sqlite structure
-func NewChadoSqlite(dbh *sqlx.DB, parser *gochado.SqlParser, org *gochado.Organism) *Sqlite {
-    return &Sqlite{parser, dbh, org}
+// Name of loader that the progress is reported with
+const loaderName = "gpad"
+
+// Sections with sql statements that transfers the data from staging tables,
+// they are run in order after feature_cvterm is loaded
+var propSections = []string{
+    "insert_feature_cvtermprop_evcode",
+    "insert_feature_cvtermprop_qualifier",
+    "insert_feature_cvtermprop_date",
+    "insert_feature_cvtermprop_assigned_by",
+    "insert_feature_cvtermprop_withfrom",
+    "insert_feature_cvterm_pub_reference",
+}
+
+// Chado table that is filled up by a section
+type target struct {
+    // name that the loaded rows are reported with
+    name string
+    // table and its primary key
+    table string
+    pk    string
+}
+
+// Targets of the sections that load chado tables
+var sectionTargets = map[string]target{
+    "insert_feature_cvterm":                 {"feature_cvterm", "feature_cvterm", "feature_cvterm_id"},
+    "insert_feature_cvtermprop_evcode":      {"feature_cvtermprop:evidence_code", "feature_cvtermprop", "feature_cvtermprop_id"},
+    "insert_feature_cvtermprop_qualifier":   {"feature_cvtermprop:qualifier", "feature_cvtermprop", "feature_cvtermprop_id"},
+    "insert_feature_cvtermprop_date":        {"feature_cvtermprop:date", "feature_cvtermprop", "feature_cvtermprop_id"},
+    "insert_feature_cvtermprop_assigned_by": {"feature_cvtermprop:source", "feature_cvtermprop", "feature_cvtermprop_id"},
+    "insert_feature_cvtermprop_withfrom":    {"feature_cvtermprop:with", "feature_cvtermprop", "feature_cvtermprop_id"},
+    "insert_feature_cvterm_pub_reference":   {"feature_cvterm_pub", "feature_cvterm_pub", "feature_cvterm_pub_id"},
+}
+
+// Sections of the ini file that are required for loading GPAD data in chado
+var RequiredSections = append([]string{
+    "select_latest_goa_count_chado",
+    "select_latest_goa_bydate_chado",
+    "insert_latest_goa_from_staging",
+    "select_temp_gpad_new_ids",
+    "update_temp_gpad_new_feature",
+    "insert_feature_cvterm",
+}, propSections...)
+
+// Create new instatnce of Sqlite structure. The dialect of parser is set from
+// the database handle and the sections are rendered with the default GPAD
+// parameters unless they are set already. Returns error if any of the
+// RequiredSections is absent in the parser.
+func NewChadoSqlite(dbh *sqlx.DB, parser *gochado.SqlParser, org *gochado.Organism) (*Sqlite, error) {
+    if len(parser.Dialect()) == 0 {
+        parser.SetDialect(gochado.DialectFor(dbh))
+    }
+    if parser.Params() == nil {
+        if err := parser.SetParams(gochado.DefaultGpadParams()); err != nil {
+            return nil, err
+        }
+    }
+    if err := parser.Require(RequiredSections...); err != nil {
+        return nil, err
+    }
+    return &Sqlite{sqlparser: parser, dbh: dbh, Organism: org, observer: gochado.NopObserver{}, logger: gochado.DefaultLogger()}, nil
+}
+
+// Validates the sql statements of RequiredSections against the database
+// without running them. The staging tables should be created beforehand.
+func (sqlite *Sqlite) Validate() error {
+    return sqlite.sqlparser.Validate(sqlite.dbh, RequiredSections...)
 }
 
 func (sqlite *Sqlite) AlterTables() {
@@ -29,42 +109,319 @@ func (sqlite *Sqlite) ResetTables() {
 
 }
 
+// Runs the statements within the transaction instead of the database handle
+func (sqlite *Sqlite) SetTx(tx *sqlx.Tx) {
+    sqlite.tx = tx
+}
+
+// Records the provenance of bulk load. The load is registered and every
+// loaded row of feature_cvterm, feature_cvtermprop and feature_cvterm_pub is
+// linked to it.
+func (sqlite *Sqlite) SetProvenance(p *gochado.Provenance, load *gochado.Load) {
+    sqlite.provenance = p
+    sqlite.load = load
+}
+
+// Sets the logger of loader along with its parser, a nil value switches back
+// to the default one
+func (sqlite *Sqlite) SetLogger(l gochado.Logger) {
+    if l == nil {
+        l = gochado.DefaultLogger()
+    }
+    sqlite.logger = l
+    sqlite.sqlparser.SetLogger(l)
+}
+
+// Sets the feature type of sequence ontology that the ids of GPAD rows are
+// resolved within, they are resolved to features of any type by default
+func (sqlite *Sqlite) SetFeatureType(ftype string) {
+    sqlite.ftype = ftype
+}
+
+// Returns the ids of the last load that matched more than one feature, their
+// rows are left out of loading
+func (sqlite *Sqlite) Ambiguities() []*gochado.AmbiguityError {
+    if sqlite.resolver == nil {
+        return nil
+    }
+    return sqlite.resolver.Ambiguities()
+}
+
+// Reports the number of rows inserted by each section along with the time
+// taken by BulkLoad
+func (sqlite *Sqlite) SetObserver(o gochado.Observer) {
+    if o == nil {
+        o = gochado.NopObserver{}
+    }
+    sqlite.observer = o
+}
+
+func (sqlite *Sqlite) handle() gochado.Handle {
+    if sqlite.tx != nil {
+        return sqlite.tx
+    }
+    return sqlite.dbh
+}
+
 func (sqlite *Sqlite) BulkLoad() {
+    if err := sqlite.BulkLoadContext(context.Background()); err != nil {
+        gochado.Fatal(sqlite.logger, "could not load chado tables", "loader", loaderName, "error", err)
+    }
+}
+
+// Same as BulkLoad, however returns the error. All the statements run in a
+// single transaction, either the external one or a new one. The new
+// transaction is rolled back on error including the cancellation of context,
+// the external one is left for the caller.
+func (sqlite *Sqlite) BulkLoadContext(ctx context.Context) error {
+    start := time.Now()
+    defer func() {
+        sqlite.observer.PhaseDone(loaderName, "chado", time.Since(start))
+    }()
+    if sqlite.tx != nil {
+        return sqlite.transfer(ctx, sqlite.tx, nil, 0)
+    }
+    tx, err := sqlite.dbh.BeginTxx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if err := sqlite.transfer(ctx, tx, nil, 0); err != nil {
+        return err
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error %s in commiting chado data", err)
+    }
+    return nil
+}
+
+// Runs the staging loader and the bulk load in a transaction that is always
+// rolled back. Reports the number of rows that would be inserted in
+// feature_cvterm, feature_cvterm_pub and feature_cvtermprop by each of its
+// type along with up to sample rows of them.
+func (sqlite *Sqlite) DryRun(staging gochado.StagingLoader, sample int) (*gochado.DryRunReport, error) {
+    return sqlite.DryRunContext(context.Background(), staging, sample)
+}
+
+// Same as DryRun, however aborts on cancellation of the context. The staging
+// loader runs with the context if it implements ContextStagingLoader.
+func (sqlite *Sqlite) DryRunContext(ctx context.Context, staging gochado.StagingLoader, sample int) (*gochado.DryRunReport, error) {
+    stx, ok := staging.(gochado.TxLoader)
+    if !ok {
+        return nil, fmt.Errorf("staging loader %T could not run in a transaction", staging)
+    }
+    tx, err := sqlite.dbh.BeginTxx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+    stx.SetTx(tx)
+    defer stx.SetTx(nil)
+    if cstaging, ok := staging.(gochado.ContextStagingLoader); ok {
+        if err := cstaging.CreateTablesContext(ctx); err != nil {
+            return nil, err
+        }
+        if err := cstaging.BulkLoadContext(ctx); err != nil {
+            return nil, err
+        }
+    } else {
+        staging.CreateTables()
+        staging.BulkLoad()
+    }
+
+    report := gochado.NewDryRunReport()
+    if err := sqlite.transfer(ctx, tx, report, sample); err != nil {
+        return nil, err
+    }
+    return report, nil
+}
+
+// Transfers data from staging to chado tables. The loaded rows are added to
+// the report unless it is nil.
+func (sqlite *Sqlite) transfer(ctx context.Context, h gochado.Handle, report *gochado.DryRunReport, sample int) error {
     parser := sqlite.sqlparser
-    dbh := sqlite.dbh
+    if sqlite.provenance != nil {
+        if err := sqlite.provenance.Register(ctx, h, sqlite.load); err != nil {
+            return err
+        }
+    }
 
     //Check for presence of and goa record
     type entries struct{ Counter int }
     e := entries{}
-    err := dbh.Get(&e, parser.GetSection("select_latest_goa_count_chado"), sqlite.Organism.Genus, sqlite.Organism.Species)
+    err := h.GetContext(ctx, &e, parser.GetSection("select_latest_goa_count_chado"), sqlite.Organism.Genus, sqlite.Organism.Species)
     if err != nil {
-        log.Fatalf("should have run the query error: %s", err)
+        return fmt.Errorf("error %s in running section %s", err, "select_latest_goa_count_chado")
     }
     grecord := 0
     // if there is any then get the date field of the latest one
     if e.Counter > 0 {
         type lt struct{ Latest int }
         l := lt{}
-        err := dbh.Get(&l, parser.GetSection("select_latest_goa_bydate_chado"), sqlite.Organism.Genus, sqlite.Organism.Species)
+        err := h.GetContext(ctx, &l, parser.GetSection("select_latest_goa_bydate_chado"), sqlite.Organism.Genus, sqlite.Organism.Species)
         if err != nil {
-            log.Fatalf("should have run the query error: %s", err)
+            return fmt.Errorf("error %s in running section %s", err, "select_latest_goa_bydate_chado")
         }
         grecord = l.Latest
     }
+    // Clear the leftover of persistent staging table from any previous run
+    if parser.HasSection("delete_temp_gpad_new") {
+        if _, err := h.ExecContext(ctx, parser.GetSection("delete_temp_gpad_new")); err != nil {
+            return fmt.Errorf("error %s in running section %s", err, "delete_temp_gpad_new")
+        }
+    }
     // First get latest GAF records in another staging table
-    dbh.Execf(parser.GetSection("insert_latest_goa_from_staging"), grecord)
-    // Now fill up the feature_cvterm
-    dbh.Execf(parser.GetSection("insert_feature_cvterm"))
-    sections := []string{
-        "feature_cvtermprop_evcode",
-        "feature_cvtermprop_qualifier",
-        "feature_cvtermprop_date",
-        "feature_cvtermprop_assigned_by",
-        "feature_cvtermprop_withfrom",
-        "feature_cvterm_pub_reference",
-    }
-    for _, s := range sections {
-        s = "insert_" + s
-        dbh.Execf(parser.GetSection(s) + ";")
+    _, err = h.ExecContext(ctx, parser.GetSection("insert_latest_goa_from_staging"), grecord)
+    if err != nil {
+        return fmt.Errorf("error %s in running section %s", err, "insert_latest_goa_from_staging")
+    }
+    if err := sqlite.resolveFeatures(ctx, h); err != nil {
+        return err
+    }
+    // The loaded rows are identified by their primary key being above the
+    // last one, keep the other writers off until the end of transaction
+    if report != nil || sqlite.provenance != nil {
+        if err := sqlite.lockTargets(ctx, h); err != nil {
+            return err
+        }
+    }
+    // Now fill up the feature_cvterm and then the rest
+    for _, s := range append([]string{"insert_feature_cvterm"}, propSections...) {
+        if err := sqlite.execSection(ctx, h, s, report, sample); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Resolves the ids of staged rows to the features of organism through
+// FeatureResolver and sets their feature_id. An id is looked up as it is and
+// then along with its db, the rows of ids that are not found or match more
+// than one feature are left out of loading.
+func (sqlite *Sqlite) resolveFeatures(ctx context.Context, h gochado.Handle) error {
+    parser := sqlite.sqlparser
+    type entry struct {
+        Db string `db:"db"`
+        Id string `db:"id"`
+    }
+    var entries []entry
+    if err := h.SelectContext(ctx, &entries, parser.GetSection("select_temp_gpad_new_ids")); err != nil {
+        return fmt.Errorf("error %s in running section %s", err, "select_temp_gpad_new_ids")
+    }
+    r := gochado.NewFeatureResolver(h, sqlite.Organism, sqlite.ftype)
+    sqlite.resolver = r
+    for _, e := range entries {
+        fid, err := r.ResolveContext(ctx, e.Id)
+        if errors.Is(err, gochado.ErrFeatureNotFound) {
+            fid, err = r.ResolveContext(ctx, e.Db+":"+e.Id)
+        }
+        var aerr *gochado.AmbiguityError
+        if errors.Is(err, gochado.ErrFeatureNotFound) || errors.As(err, &aerr) {
+            sqlite.logger.Warn("skipped rows of unresolved id", "loader", loaderName, "db", e.Db, "id", e.Id, "error", err)
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        if _, err := h.ExecContext(ctx, parser.GetSection("update_temp_gpad_new_feature"), fid, e.Db, e.Id); err != nil {
+            return fmt.Errorf("error %s in running section %s", err, "update_temp_gpad_new_feature")
+        }
+    }
+    return nil
+}
+
+// Keeps the other writers off the target tables for the rest of transaction.
+// Postgres locks the tables against concurrent writes while allowing reads.
+// Sqlite needs no lock as its transactions are serializable, the first write
+// of transaction fails with busy error if another writer has committed since
+// its read, so rows of other writers never get linked to the load.
+func (sqlite *Sqlite) lockTargets(ctx context.Context, h gochado.Handle) error {
+    if gochado.DialectFor(h) != "postgres" {
+        return nil
+    }
+    tables := make([]string, 0)
+    seen := make(map[string]bool)
+    for _, s := range append([]string{"insert_feature_cvterm"}, propSections...) {
+        t := sectionTargets[s].table
+        if !seen[t] {
+            seen[t] = true
+            tables = append(tables, t)
+        }
+    }
+    q := fmt.Sprintf("LOCK TABLE %s IN SHARE ROW EXCLUSIVE MODE", strings.Join(tables, ", "))
+    if _, err := h.ExecContext(ctx, q); err != nil {
+        return fmt.Errorf("error %s in locking tables %s", err, strings.Join(tables, ","))
+    }
+    return nil
+}
+
+// Runs a section that loads a chado table, adds the loaded rows to the
+// report and links them to the load
+func (sqlite *Sqlite) execSection(ctx context.Context, h gochado.Handle, section string, report *gochado.DryRunReport, sample int) error {
+    t := sectionTargets[section]
+    var last int64
+    if report != nil || sqlite.provenance != nil {
+        err := h.GetContext(ctx, &last, fmt.Sprintf("SELECT COALESCE(MAX(%s), 0) FROM %s", t.pk, t.table))
+        if err != nil {
+            return fmt.Errorf("error %s in retrieving last id of %s", err, t.table)
+        }
+    }
+    res, err := h.ExecContext(ctx, sqlite.sqlparser.GetSection(section))
+    if err != nil {
+        return fmt.Errorf("error %s in running section %s", err, section)
+    }
+    count, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    // the rows of a dry run are rolled back, so they are neither reported
+    // as inserted nor counted by the observer
+    if report == nil {
+        sqlite.observer.RowsInserted(loaderName, section, count)
+        sqlite.logger.Info("inserted rows", "loader", loaderName, "section", section, "table", t.table, "rows", count)
+    } else {
+        sqlite.logger.Debug("dry run rows", "loader", loaderName, "section", section, "table", t.table, "rows", count)
+    }
+    if sqlite.provenance != nil {
+        err := sqlite.provenance.LinkRows(ctx, h, sqlite.load, t.name, t.table, t.pk, last)
+        if err != nil {
+            return err
+        }
+    }
+    if report == nil {
+        return nil
+    }
+    samples, err := sampleRows(ctx, h, t, last, sample)
+    if err != nil {
+        return fmt.Errorf("error %s in retrieving sample rows of %s", err, t.table)
+    }
+    report.Add(t.name, count, samples)
+    return nil
+}
+
+// Retrieves up to limit rows of a target that are inserted after the last id
+func sampleRows(ctx context.Context, h gochado.Handle, t target, last int64, limit int) ([]map[string]interface{}, error) {
+    samples := make([]map[string]interface{}, 0)
+    if limit < 1 {
+        return samples, nil
+    }
+    q := fmt.Sprintf("SELECT * FROM %s WHERE %s > ? ORDER BY %s LIMIT ?", t.table, t.pk, t.pk)
+    rows, err := h.QueryxContext(ctx, h.Rebind(q), last, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        m := make(map[string]interface{})
+        if err := rows.MapScan(m); err != nil {
+            return nil, err
+        }
+        for k, v := range m {
+            if b, ok := v.([]byte); ok {
+                m[k] = string(b)
+            }
+        }
+        samples = append(samples, m)
     }
+    return samples, rows.Err()
 }
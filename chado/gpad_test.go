@@ -2,6 +2,7 @@ package chado
 
 import (
     "bytes"
+    "context"
     "encoding/gob"
     "github.com/GeertJohan/go.rice"
     "github.com/dictybase/gochado"
@@ -9,6 +10,7 @@ import (
     "github.com/dictybase/testchado"
     . "github.com/dictybase/testchado/matchers"
     . "github.com/onsi/gomega"
+    "strings"
     "testing"
 )
 
@@ -45,19 +47,20 @@ func LoadGpadChadoFixtureSqlite(chado testchado.DBManager, t *testing.T, b *rice
     _ = f.LoadMiscCvterms("gene_ontology_association")
 }
 
-func LoadGpadStagingSqlite(chado testchado.DBManager, t *testing.T, b *rice.Box) {
-    // test struct creation and table handling
-    str, err := b.String("sqlite_gpad.ini")
+// Gets a staging loader with the rows of test.gpad added, the staging tables
+// are neither created nor loaded
+func AddGpadStagingSqlite(chado testchado.DBManager, t *testing.T, b *rice.Box) *staging.Sqlite {
+    parser, err := gochado.SQLFor("gpad", "sqlite")
     if err != nil {
-        t.Errorf("could not open file sqlite_gpad.ini from rice box error:%s", err)
+        t.Fatalf("could not get sql for gpad loader error:%s", err)
+    }
+    staging, err := staging.NewStagingSqlite(chado.DBHandle(), parser)
+    if err != nil {
+        t.Fatalf("could not create staging loader error: %s", err)
     }
-    staging := staging.NewStagingSqlite(chado.DBHandle(), gochado.NewSqlParserFromString(str))
-    staging.CreateTables()
-
-    // test data buffering
     gpstr, err := b.String("test.gpad")
     if err != nil {
-        t.Error(err)
+        t.Fatal(err)
     }
     buff := bytes.NewBufferString(gpstr)
     for {
@@ -67,8 +70,15 @@ func LoadGpadStagingSqlite(chado testchado.DBManager, t *testing.T, b *rice.Box)
         }
         staging.AddDataRow(line)
     }
-    //bulkload testing
+    return staging
+}
+
+// Loads the rows of test.gpad in staging tables
+func LoadGpadStagingSqlite(chado testchado.DBManager, t *testing.T, b *rice.Box) *staging.Sqlite {
+    staging := AddGpadStagingSqlite(chado, t, b)
+    staging.CreateTables()
     staging.BulkLoad()
+    return staging
 }
 
 func TestGpadChadoSqlite(t *testing.T) {
@@ -86,11 +96,13 @@ func TestGpadChadoSqlite(t *testing.T) {
     defer chado.DropSchema()
 
     dbh := chado.DBHandle()
-    str, err := b.String("sqlite_gpad.ini")
+    p, err := gochado.SQLFor("gpad", "sqlite")
     if err != nil {
-        t.Errorf("could not open file sqlite_gpad.ini from rice box error:%s", err)
+        t.Errorf("could not get sql for gpad loader error:%s", err)
+    }
+    if err := p.SetParams(gochado.DefaultGpadParams()); err != nil {
+        t.Fatalf("could not set template parameters error: %s", err)
     }
-    p := gochado.NewSqlParserFromString(str)
     type entries struct{ Counter int }
     e := entries{}
     err = dbh.Get(&e, p.GetSection("select_latest_goa_count_chado"), "Dictyostelium", "disocideum")
@@ -111,6 +123,14 @@ func TestGpadChadoSqlite(t *testing.T) {
 
     dbh.Execf(p.GetSection("insert_latest_goa_from_staging"), grecord)
     Expect("SELECT COUNT(*) FROM temp_gpad_new").Should(HaveCount(10))
+    sqlite, err := NewChadoSqlite(dbh, p, &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"})
+    if err != nil {
+        t.Fatalf("could not create chado loader error: %s", err)
+    }
+    if err := sqlite.resolveFeatures(context.Background(), dbh); err != nil {
+        t.Fatalf("could not resolve features error: %s", err)
+    }
+    Expect("SELECT COUNT(*) FROM temp_gpad_new WHERE feature_id IS NULL").Should(HaveCount(0))
     // Check if goid is present in dbxref
     type gpad struct {
         Id       string
@@ -225,15 +245,25 @@ func TestGpadChadoSqliteBulk(t *testing.T) {
     defer chado.DropSchema()
 
     dbh := chado.DBHandle()
-    str, err := b.String("sqlite_gpad.ini")
+    p, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Errorf("could not get sql for gpad loader error:%s", err)
+    }
+    sqlite, err := NewChadoSqlite(dbh, p, &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"})
     if err != nil {
-        t.Errorf("could not open file sqlite_gpad.ini from rice box error:%s", err)
+        t.Fatalf("could not create chado loader error: %s", err)
     }
-    p := gochado.NewSqlParserFromString(str)
-    sqlite := NewChadoSqlite(dbh, p, &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"})
+    metrics := gochado.NewMetrics()
+    sqlite.SetObserver(metrics)
     sqlite.BulkLoad()
     Expect("SELECT COUNT(*) FROM temp_gpad_new").Should(HaveCount(10))
     Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(10))
+    if v := metrics.Value("gochado_rows_inserted_total", "gpad", "insert_feature_cvterm"); v != 10 {
+        t.Errorf("expected %d inserted rows reported got %v", 10, v)
+    }
+    if v := metrics.Value("gochado_rows_inserted_total", "gpad", "insert_feature_cvtermprop_withfrom"); v != 5 {
+        t.Errorf("expected %d inserted rows reported got %v", 5, v)
+    }
     eq := `
     SELECT COUNT(*)  FROM feature_cvtermprop
     JOIN cvterm ON cvterm.cvterm_id = feature_cvtermprop.type_id
@@ -267,3 +297,328 @@ func TestGpadChadoSqliteBulk(t *testing.T) {
     Expect(q).Should(HaveNameCount(m))
     Expect("SELECT COUNT(*) FROM feature_cvterm_pub").Should(HaveCount(1))
 }
+
+func TestGpadChadoSqliteValidate(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    RegisterDBHandler(chado)
+    chado.DeploySchema()
+    defer chado.DropSchema()
+    b := rice.MustFindBox("../data")
+    LoadGpadStagingSqlite(chado, t, b)
+
+    dbh := chado.DBHandle()
+    org := &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"}
+    p, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Fatalf("could not get sql for gpad loader error: %s", err)
+    }
+    sqlite, err := NewChadoSqlite(dbh, p, org)
+    if err != nil {
+        t.Fatalf("could not create chado loader error: %s", err)
+    }
+    if err := sqlite.Validate(); err != nil {
+        t.Errorf("should have validated all sections error: %s", err)
+    }
+
+    // break two of the sections
+    content, err := gochado.SQLContent("gpad")
+    if err != nil {
+        t.Fatal(err)
+    }
+    content = strings.Replace(content, "pub.pubplace = temp_gpad_new.pubplace", "pub.pubplaces = temp_gpad_new.pubplace", 1)
+    content = strings.Replace(content, "temp_gpad_withfrom.withfrom", "temp_gpad_withfrom.with_from", 1)
+    bp, err := gochado.NewSqlParserFromString(content)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sqlite, err = NewChadoSqlite(dbh, bp, org)
+    if err != nil {
+        t.Fatalf("could not create chado loader error: %s", err)
+    }
+    err = sqlite.Validate()
+    verr, ok := err.(gochado.ValidationError)
+    if !ok {
+        t.Fatalf("expected validation error got %v", err)
+    }
+    if len(verr) != 2 {
+        t.Fatalf("expected %d invalid sections got %d", 2, len(verr))
+    }
+    for i, s := range []string{"insert_feature_cvterm", "insert_feature_cvtermprop_withfrom"} {
+        if verr[i].Section != s {
+            t.Errorf("expected invalid section %s got %s", s, verr[i].Section)
+        }
+    }
+    Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(0))
+}
+
+func TestGpadChadoSqliteDryRun(t *testing.T) {
+    RegisterTestingT(t)
+    chado := testchado.NewSQLiteManager()
+    RegisterDBHandler(chado)
+    chado.DeploySchema()
+    chado.LoadPresetFixture("eco")
+    defer chado.DropSchema()
+    b := rice.MustFindBox("../data")
+    LoadGpadChadoFixtureSqlite(chado, t, b)
+
+    dbh := chado.DBHandle()
+    staging := AddGpadStagingSqlite(chado, t, b)
+    p, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Fatalf("could not get sql for gpad loader error: %s", err)
+    }
+    sqlite, err := NewChadoSqlite(dbh, p, &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"})
+    if err != nil {
+        t.Fatalf("could not create chado loader error: %s", err)
+    }
+    metrics := gochado.NewMetrics()
+    sqlite.SetObserver(metrics)
+    report, err := sqlite.DryRun(staging, 2)
+    if err != nil {
+        t.Fatalf("should have run the dry run error: %s", err)
+    }
+    for target, count := range map[string]int64{
+        "feature_cvterm":                   10,
+        "feature_cvtermprop:evidence_code": 10,
+        "feature_cvtermprop:qualifier":     10,
+        "feature_cvtermprop:date":          10,
+        "feature_cvtermprop:source":        10,
+        "feature_cvtermprop:with":          5,
+        "feature_cvterm_pub":               1,
+    } {
+        if report.Counts[target] != count {
+            t.Errorf("expected %d rows for %s got %d", count, target, report.Counts[target])
+        }
+    }
+    if len(report.Targets) != 7 {
+        t.Errorf("expected %d targets got %d", 7, len(report.Targets))
+    }
+    if len(report.Samples["feature_cvterm"]) != 2 {
+        t.Errorf("expected %d sample rows got %d", 2, len(report.Samples["feature_cvterm"]))
+    }
+    if len(report.Samples["feature_cvterm_pub"]) != 1 {
+        t.Errorf("expected %d sample rows got %d", 1, len(report.Samples["feature_cvterm_pub"]))
+    }
+    for _, row := range report.Samples["feature_cvtermprop:qualifier"] {
+        if _, ok := row["value"]; !ok {
+            t.Error("sample row should have value column")
+        }
+    }
+    if v := metrics.Value("gochado_rows_inserted_total", "gpad", "insert_feature_cvterm"); v != 0 {
+        t.Errorf("dry run should not report inserted rows got %v", v)
+    }
+    // nothing should be left behind
+    Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(0))
+    Expect("SELECT COUNT(*) FROM feature_cvtermprop").Should(HaveCount(0))
+    Expect("SELECT COUNT(*) FROM sqlite_temp_master WHERE type = 'table'").Should(HaveCount(0))
+
+    // the real run afterwards
+    staging.CreateTables()
+    staging.BulkLoad()
+    sqlite.BulkLoad()
+    Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(10))
+}
+
+func TestGpadChadoSqliteProvenance(t *testing.T) {
+    RegisterTestingT(t)
+    chado := testchado.NewSQLiteManager()
+    RegisterDBHandler(chado)
+    chado.DeploySchema()
+    chado.LoadPresetFixture("eco")
+    defer chado.DropSchema()
+    b := rice.MustFindBox("../data")
+    LoadGpadChadoFixtureSqlite(chado, t, b)
+
+    dbh := chado.DBHandle()
+    staging := LoadGpadStagingSqlite(chado, t, b)
+    if staging.SourceVersion() != "1.1" {
+        t.Errorf("expected gpa-version %s got %s", "1.1", staging.SourceVersion())
+    }
+
+    prov, err := gochado.NewProvenance(dbh)
+    if err != nil {
+        t.Fatalf("could not create provenance error: %s", err)
+    }
+    if err := prov.CreateTables(); err != nil {
+        t.Fatalf("could not create provenance tables error: %s", err)
+    }
+    load, err := gochado.NewLoad("gpad", "../data/test.gpad")
+    if err != nil {
+        t.Fatalf("could not create load error: %s", err)
+    }
+    load.SourceVersion = staging.SourceVersion()
+
+    p, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Fatalf("could not get sql for gpad loader error: %s", err)
+    }
+    sqlite, err := NewChadoSqlite(dbh, p, &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"})
+    if err != nil {
+        t.Fatalf("could not create chado loader error: %s", err)
+    }
+    sqlite.SetProvenance(prov, load)
+    sqlite.BulkLoad()
+    if load.LoadId == 0 {
+        t.Fatal("load should have been registered")
+    }
+    Expect("SELECT COUNT(*) FROM gochado_load").Should(HaveCount(1))
+    Expect("SELECT COUNT(*) FROM gochado_load_row WHERE table_name = 'feature_cvterm'").Should(HaveCount(10))
+    Expect("SELECT COUNT(*) FROM gochado_load_row WHERE table_name = 'feature_cvtermprop'").Should(HaveCount(45))
+    Expect("SELECT COUNT(*) FROM gochado_load_row WHERE table_name = 'feature_cvterm_pub'").Should(HaveCount(1))
+
+    stored, err := prov.Find(load.LoadId)
+    if err != nil {
+        t.Fatalf("could not find load error: %s", err)
+    }
+    if stored.Checksum != load.Checksum {
+        t.Errorf("expected checksum %s got %s", load.Checksum, stored.Checksum)
+    }
+    if stored.SourceVersion != "1.1" {
+        t.Errorf("expected source version %s got %s", "1.1", stored.SourceVersion)
+    }
+    if stored.LoaderVersion != gochado.Version {
+        t.Errorf("expected loader version %s got %s", gochado.Version, stored.LoaderVersion)
+    }
+    for target, count := range map[string]int64{
+        "feature_cvterm":          10,
+        "feature_cvtermprop:with": 5,
+        "feature_cvterm_pub":      1,
+    } {
+        if stored.Counts[target] != count {
+            t.Errorf("expected %d rows for %s got %d", count, target, stored.Counts[target])
+        }
+    }
+
+    var id int64
+    if err := dbh.Get(&id, "SELECT MIN(feature_cvterm_id) FROM feature_cvterm"); err != nil {
+        t.Fatal(err)
+    }
+    byrow, err := prov.FindByRow("feature_cvterm", id)
+    if err != nil {
+        t.Fatalf("could not find load of feature_cvterm row error: %s", err)
+    }
+    if byrow.LoadId != load.LoadId {
+        t.Errorf("expected load %d got %d", load.LoadId, byrow.LoadId)
+    }
+
+    // a row of another writer that refers to a loaded feature_cvterm
+    _, err = dbh.Exec(`
+        INSERT INTO feature_cvtermprop(feature_cvterm_id, type_id, value, rank)
+        SELECT feature_cvterm_id, type_id, 'curator', 99 FROM feature_cvtermprop
+        WHERE feature_cvterm_id = ? LIMIT 1`, id)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // revert the load
+    report, err := prov.Revert(load.LoadId)
+    if err != nil {
+        t.Fatalf("could not revert load error: %s", err)
+    }
+    if report.Dependents["feature_cvtermprop"] != 1 {
+        t.Errorf("expected 1 removed dependent row of feature_cvtermprop got %d", report.Dependents["feature_cvtermprop"])
+    }
+    for table, count := range map[string]int64{
+        "feature_cvterm":     10,
+        "feature_cvtermprop": 45,
+        "feature_cvterm_pub": 1,
+    } {
+        if report.Counts[table] != count {
+            t.Errorf("expected %d removed rows of %s got %d", count, table, report.Counts[table])
+        }
+    }
+    Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(0))
+    Expect("SELECT COUNT(*) FROM feature_cvtermprop").Should(HaveCount(0))
+    Expect("SELECT COUNT(*) FROM feature_cvterm_pub").Should(HaveCount(0))
+    Expect("SELECT COUNT(*) FROM gochado_load").Should(HaveCount(0))
+    Expect("SELECT COUNT(*) FROM gochado_load_row").Should(HaveCount(0))
+    if _, err := prov.Revert(load.LoadId); err == nil {
+        t.Error("should have failed to revert an absent load")
+    }
+}
+
+func TestGpadChadoSqliteContext(t *testing.T) {
+    RegisterTestingT(t)
+    chado := testchado.NewSQLiteManager()
+    RegisterDBHandler(chado)
+    chado.DeploySchema()
+    chado.LoadPresetFixture("eco")
+    defer chado.DropSchema()
+    b := rice.MustFindBox("../data")
+    LoadGpadStagingSqlite(chado, t, b)
+    LoadGpadChadoFixtureSqlite(chado, t, b)
+
+    p, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Fatalf("could not get sql for gpad loader error: %s", err)
+    }
+    sqlite, err := NewChadoSqlite(chado.DBHandle(), p, &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"})
+    if err != nil {
+        t.Fatalf("could not create chado loader error: %s", err)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := sqlite.BulkLoadContext(ctx); err == nil {
+        t.Error("should have failed with cancelled context")
+    }
+    Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(0))
+    Expect("SELECT COUNT(*) FROM temp_gpad_new").Should(HaveCount(0))
+
+    if err := sqlite.BulkLoadContext(context.Background()); err != nil {
+        t.Fatalf("should have loaded chado tables error: %s", err)
+    }
+    Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(10))
+}
+
+func TestGpadChadoSqliteResolve(t *testing.T) {
+    RegisterTestingT(t)
+    chado := testchado.NewSQLiteManager()
+    RegisterDBHandler(chado)
+    chado.DeploySchema()
+    chado.LoadPresetFixture("eco")
+    defer chado.DropSchema()
+    b := rice.MustFindBox("../data")
+    LoadGpadChadoFixtureSqlite(chado, t, b)
+
+    // a gene that is known by its UniProtKB accession in gpad
+    dbh := chado.DBHandle()
+    gene := gochado.NewGpadFixtureLoader(chado).LoadGenes([]string{"DDB_G0299999"})[0]
+    xref, err := gochado.NewChadoHelper(dbh).FindOrCreateDbxrefId("UniProtKB", "Q99999", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := dbh.Exec("INSERT INTO feature_dbxref(feature_id, dbxref_id) VALUES($1, $2)", gene.FeatureId, xref); err != nil {
+        t.Fatal(err)
+    }
+    staging := AddGpadStagingSqlite(chado, t, b)
+    for _, acc := range []string{"Q99999", "Q00000"} {
+        staging.AddDataRow(strings.Join([]string{
+            "UniProtKB", acc, "enables", "GO:0001614", "GO_REF:0000002",
+            "ECO:0000256", "", "", "20140222", "InterPro", "", "",
+        }, "\t"))
+    }
+    staging.CreateTables()
+    staging.BulkLoad()
+
+    p, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Fatalf("could not get sql for gpad loader error: %s", err)
+    }
+    sqlite, err := NewChadoSqlite(dbh, p, &gochado.Organism{Genus: "Dictyostelium", Species: "discoideum"})
+    if err != nil {
+        t.Fatalf("could not create chado loader error: %s", err)
+    }
+    sqlite.BulkLoad()
+    // the unknown accession is left out
+    Expect("SELECT COUNT(*) FROM feature_cvterm").Should(HaveCount(11))
+    var count int
+    if err := dbh.Get(&count, "SELECT COUNT(*) FROM feature_cvterm WHERE feature_id = $1", gene.FeatureId); err != nil {
+        t.Fatal(err)
+    }
+    if count != 1 {
+        t.Errorf("expected %d annotation of the gene resolved by accession got %d", 1, count)
+    }
+    if a := sqlite.Ambiguities(); len(a) != 0 {
+        t.Errorf("expected no ambiguous id got %v", a)
+    }
+}
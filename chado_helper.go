@@ -1,16 +1,17 @@
 package gochado
 
 import (
-    "bytes"
+    "container/list"
+    "context"
     "crypto/md5"
+    "database/sql"
     "encoding/hex"
     "fmt"
     "github.com/jmoiron/sqlx"
-    "io"
-    "io/ioutil"
-    "log"
+    "reflect"
     "strings"
     "sync"
+    "time"
 )
 
 // Returns MD5 hash of string
@@ -26,159 +27,668 @@ type Database struct {
     ChadoHandler *sqlx.DB
 }
 
+// Database handle for running sql statements, it is satisfied by both
+// *sqlx.DB and *sqlx.Tx. It allows the same code to run either with its own
+// connection or within a transaction.
+type Handle interface {
+    DriverName() string
+    Rebind(query string) string
+    Exec(query string, args ...interface{}) (sql.Result, error)
+    Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+    QueryRowx(query string, args ...interface{}) *sqlx.Row
+    Preparex(query string) (*sqlx.Stmt, error)
+    Get(dest interface{}, query string, args ...interface{}) error
+    Select(dest interface{}, query string, args ...interface{}) error
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+    QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+    QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
+    PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error)
+    GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+    SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
 // A simple thread safe cache for holding key(string) value(int). This is a
 // typical use case for working with chado database where db,dbxref, cv
 // and cvterm entries are shared as foreign keys between most of the tables.
 // Caching those foreign keys with a unique name reduces redundant database
-// lookups.
+// lookups. The cache is unbounded by default, it could be limited by
+// capacity with the least recently used entries evicted and the entries
+// could be expired after a fixed duration.
+/*
+    dc := NewDataCache(WithCapacity(100000), WithTTL(time.Hour))
+    dc.Set("GO:0001614", 59)
+    if id, ok := dc.Lookup("GO:0001614"); ok {
+        fmt.Println(id)
+    }
+    dc.Stats() // CacheStats{Hits: 1, Misses: 0, Evictions: 0, Len: 1}
+*/
 type DataCache struct {
-    cache map[string]int
+    cache map[string]*list.Element
+    // entries from the most to the least recently used
+    order *list.List
+    // maximum number of entries, zero for unbounded
+    capacity int
+    // lifetime of an entry, zero for no expiry
+    ttl   time.Duration
+    stats CacheStats
+    // current time for expiry, replaced by tests
+    now func() time.Time
     sync.RWMutex
 }
 
+// An entry of DataCache
+type cacheEntry struct {
+    key     string
+    id      int
+    expires time.Time
+}
+
+// Statistics of DataCache since its creation or last Clear, the hits and
+// misses are counted by Has and Lookup. Len is the number of live entries,
+// the expired ones are left out.
+type CacheStats struct {
+    Hits      int64
+    Misses    int64
+    Evictions int64
+    Len       int
+}
+
+// Option for creating DataCache
+type CacheOption func(*DataCache)
+
+// Limits the number of entries, the least recently used one is evicted once
+// the limit is reached. Values less than one are ignored.
+func WithCapacity(capacity int) CacheOption {
+    return func(dc *DataCache) {
+        if capacity > 0 {
+            dc.capacity = capacity
+        }
+    }
+}
+
+// Expires the entries after ttl from their addition. Values less than one
+// are ignored.
+func WithTTL(ttl time.Duration) CacheOption {
+    return func(dc *DataCache) {
+        if ttl > 0 {
+            dc.ttl = ttl
+        }
+    }
+}
+
 // New instance of Datacache
-func NewDataCache() *DataCache {
-    return &DataCache{cache: make(map[string]int)}
+func NewDataCache(opts ...CacheOption) *DataCache {
+    dc := &DataCache{cache: make(map[string]*list.Element), order: list.New(), now: time.Now}
+    for _, o := range opts {
+        o(dc)
+    }
+    return dc
 }
 
 // Add an entry to the cache
 func (dc *DataCache) Set(key string, id int) {
     dc.Lock()
     defer dc.Unlock()
-    dc.cache[key] = id
+    var expires time.Time
+    if dc.ttl > 0 {
+        expires = dc.now().Add(dc.ttl)
+    }
+    if el, ok := dc.cache[key]; ok {
+        e := el.Value.(*cacheEntry)
+        e.id = id
+        e.expires = expires
+        dc.order.MoveToFront(el)
+        return
+    }
+    dc.cache[key] = dc.order.PushFront(&cacheEntry{key: key, id: id, expires: expires})
+    if dc.capacity > 0 && dc.order.Len() > dc.capacity {
+        dc.remove(dc.order.Back())
+        dc.stats.Evictions++
+    }
 }
 
 // Remove an entry
 func (dc *DataCache) Remove(key string) {
     dc.Lock()
     defer dc.Unlock()
-    delete(dc.cache, key)
+    if el, ok := dc.cache[key]; ok {
+        dc.remove(el)
+    }
+}
+
+func (dc *DataCache) remove(el *list.Element) {
+    dc.order.Remove(el)
+    delete(dc.cache, el.Value.(*cacheEntry).key)
+}
+
+// Returns the live entry of key and marks it as recently used, the expired
+// one is removed
+func (dc *DataCache) lookup(key string) (*cacheEntry, bool) {
+    el, ok := dc.cache[key]
+    if !ok {
+        return nil, false
+    }
+    e := el.Value.(*cacheEntry)
+    if dc.expired(e) {
+        dc.remove(el)
+        return nil, false
+    }
+    dc.order.MoveToFront(el)
+    return e, true
+}
+
+func (dc *DataCache) expired(e *cacheEntry) bool {
+    return dc.ttl > 0 && dc.now().After(e.expires)
 }
 
 // Check for the presence of an entry
 func (dc *DataCache) Has(key string) bool {
-    dc.RLock()
-    defer dc.RUnlock()
-    if _, ok := dc.cache[key]; ok {
+    dc.Lock()
+    defer dc.Unlock()
+    if _, ok := dc.lookup(key); ok {
+        dc.stats.Hits++
         return true
     }
+    dc.stats.Misses++
     return false
 }
 
-// Retrieve an entry from the cache. However, it is advisable to run *Has*
-// method before retrieving the entry.
+// Retrieves an entry along with its presence, counts a hit or miss
+func (dc *DataCache) Lookup(key string) (int, bool) {
+    dc.Lock()
+    defer dc.Unlock()
+    if e, ok := dc.lookup(key); ok {
+        dc.stats.Hits++
+        return e.id, true
+    }
+    dc.stats.Misses++
+    return 0, false
+}
+
+// Retrieve an entry from the cache, zero if it is absent. The entry could be
+// evicted or expired after a check with *Has*, so *Lookup* is preferable
+// for retrieving an entry that might be absent.
 func (dc *DataCache) Get(key string) (id int) {
-    dc.RLock()
-    defer dc.RUnlock()
-    if v, ok := dc.cache[key]; ok {
-        id = v
+    dc.Lock()
+    defer dc.Unlock()
+    if e, ok := dc.lookup(key); ok {
+        id = e.id
     }
     return
 }
 
-// Removes all entries from cache
+// Removes all entries from cache and resets its statistics
 func (dc *DataCache) Clear() {
     dc.Lock()
     defer dc.Unlock()
-    dc.cache = make(map[string]int)
+    dc.cache = make(map[string]*list.Element)
+    dc.order.Init()
+    dc.stats = CacheStats{}
+}
+
+// Returns the statistics of cache
+func (dc *DataCache) Stats() CacheStats {
+    dc.RLock()
+    defer dc.RUnlock()
+    st := dc.stats
+    for el := dc.order.Front(); el != nil; el = el.Next() {
+        if !dc.expired(el.Value.(*cacheEntry)) {
+            st.Len++
+        }
+    }
+    return st
 }
 
 // Helper for finding and creating cv, cvterm , db and dbxrefs in chado
-// database.
+// database. The identifiers are cached by their names, the cvterms both by
+// cv and name and by db and accession of their dbxrefs.
 type ChadoHelper struct {
     *Database
     caches map[string]*DataCache
+    logger Logger
+    // deduplicates concurrent find or create of the same entry
+    flight *flightGroup
 }
 
 // Gets a new instance
 func NewChadoHelper(dbh *sqlx.DB) *ChadoHelper {
     m := make(map[string]*DataCache)
-    for _, name := range []string{"db", "cv", "cvterm", "dbxref"} {
+    for _, name := range []string{"db", "cv", "cvterm", "dbxref", "accession"} {
         m[name] = NewDataCache()
     }
-    return &ChadoHelper{&Database{ChadoHandler: dbh}, m}
+    return &ChadoHelper{Database: &Database{ChadoHandler: dbh}, caches: m, logger: DefaultLogger(), flight: newFlightGroup()}
+}
+
+// Sets the logger, a nil value switches back to the default one
+func (helper *ChadoHelper) SetLogger(l Logger) {
+    if l == nil {
+        l = DefaultLogger()
+    }
+    helper.logger = l
+}
+
+func (helper *ChadoHelper) Logger() Logger {
+    return helper.logger
+}
+
+// Replaces one of the db, cv, cvterm, dbxref or accession caches, for
+// example with a bounded one. It should be called before the helper is used.
+func (helper *ChadoHelper) SetCache(name string, dc *DataCache) error {
+    if _, ok := helper.caches[name]; !ok {
+        return fmt.Errorf("unknown cache %s", name)
+    }
+    helper.caches[name] = dc
+    return nil
+}
+
+// Returns one of the caches, nil if it is unknown
+func (helper *ChadoHelper) Cache(name string) *DataCache {
+    return helper.caches[name]
 }
 
 // Given a db name returns its primary key identifier. The lookup is done on
 // the cache first and if absent retrieved from db table.
 func (helper *ChadoHelper) FindOrCreateDbId(db string) (int, error) {
+    return helper.FindOrCreateDbIdContext(context.Background(), db)
+}
+
+// Same as FindOrCreateDbId, however aborts on cancellation of the context
+func (helper *ChadoHelper) FindOrCreateDbIdContext(ctx context.Context, db string) (int, error) {
     dbcache := helper.caches["db"]
-    if dbcache.Has(db) {
-        return dbcache.Get(db), nil
+    if id, ok := dbcache.Lookup(db); ok {
+        return id, nil
     }
-    sqlx := helper.Database.ChadoHandler
-    q := "SELECT db_id FROM db WHERE name = $1"
-    row := sqlx.QueryRowx(q, db)
-    var dbid int
-    _ = row.Scan(&dbid)
-    if dbid != 0 {
-        dbcache.Set(db, dbid)
-        return dbid, nil
-    }
-
-    tx := sqlx.MustBegin()
-    result := tx.Execl("INSERT INTO db(name) VALUES($1)", db)
-    id64, err := result.LastInsertId()
+    return helper.flight.Do(ctx, "db-"+db, func() (int, error) {
+        id, err := helper.findOrInsert(ctx, "db", 1, []string{"name"}, []interface{}{db})
+        if err != nil {
+            return 0, fmt.Errorf("error %s in retreiving db_id", err)
+        }
+        dbcache.Set(db, id)
+        return id, nil
+    })
+}
+
+// Given a cv namespace returns its primary key identifier. The lookup is done on
+// the cache first and if absent retrieved from cv table.
+func (helper *ChadoHelper) FindOrCreateCvId(cv string) (int, error) {
+    return helper.FindOrCreateCvIdContext(context.Background(), cv)
+}
+
+// Same as FindOrCreateCvId, however aborts on cancellation of the context
+func (helper *ChadoHelper) FindOrCreateCvIdContext(ctx context.Context, cv string) (int, error) {
+    cvcache := helper.caches["cv"]
+    if id, ok := cvcache.Lookup(cv); ok {
+        return id, nil
+    }
+    return helper.flight.Do(ctx, "cv-"+cv, func() (int, error) {
+        id, err := helper.findOrInsert(ctx, "cv", 1, []string{"name"}, []interface{}{cv})
+        if err != nil {
+            return 0, err
+        }
+        cvcache.Set(cv, id)
+        return id, nil
+    })
+}
+
+// A cvterm along with its dbxref
+type cvtermRow struct {
+    CvtermId  int    `db:"cvterm_id"`
+    Cv        string `db:"cv"`
+    Name      string `db:"name"`
+    DbxrefId  int    `db:"dbxref_id"`
+    Db        string `db:"db"`
+    Accession string `db:"accession"`
+    Version   string `db:"version"`
+}
+
+const cvtermRowQuery = `
+    SELECT cvterm.cvterm_id, cv.name cv, cvterm.name, dbxref.dbxref_id,
+    db.name db, dbxref.accession, dbxref.version
+    FROM cvterm JOIN cv ON cv.cv_id = cvterm.cv_id
+    JOIN dbxref ON dbxref.dbxref_id = cvterm.dbxref_id
+    JOIN db ON db.db_id = dbxref.db_id
+    WHERE cvterm.is_obsolete = 0
+    `
+
+// Caches the identifiers of a cvterm and its dbxref
+func (helper *ChadoHelper) cacheCvterm(r cvtermRow) {
+    helper.caches["cvterm"].Set(r.Cv+"-"+r.Name, r.CvtermId)
+    helper.caches["dbxref"].Set(r.Db+"-"+r.Accession+"-"+r.Version, r.DbxrefId)
+    if len(r.Version) == 0 || !helper.caches["accession"].Has(r.Db+":"+r.Accession) {
+        helper.caches["accession"].Set(r.Db+":"+r.Accession, r.CvtermId)
+    }
+}
+
+// Warms the caches with all the cvterms of a cv and their dbxrefs through a
+// single query. Returns the number of cached cvterms.
+func (helper *ChadoHelper) PreloadCv(cv string) (int, error) {
+    return helper.PreloadCvContext(context.Background(), cv)
+}
+
+// Same as PreloadCv, however aborts on cancellation of the context
+func (helper *ChadoHelper) PreloadCvContext(ctx context.Context, cv string) (int, error) {
+    dbh := helper.Database.ChadoHandler
+    var rows []cvtermRow
+    err := dbh.SelectContext(ctx, &rows, dbh.Rebind(cvtermRowQuery+" AND cv.name = ?"), cv)
     if err != nil {
-        _ = tx.Rollback()
-        return 0, fmt.Errorf("error %s in retreiving db_id", err)
+        return 0, fmt.Errorf("error %s in preloading cv %s", err, cv)
     }
-    err = tx.Commit()
+    for _, r := range rows {
+        helper.cacheCvterm(r)
+    }
+    helper.logger.Debug("preloaded cv", "cv", cv, "cvterms", len(rows))
+    return len(rows), nil
+}
+
+// Given a dbxref of Db:Accession form returns the primary key identifier of
+// its cvterm. The lookup is done on the cache first and if absent retrieved
+// from the database.
+func (helper *ChadoHelper) FindCvtermIdByAccession(dbxref string) (int, error) {
+    return helper.FindCvtermIdByAccessionContext(context.Background(), dbxref)
+}
+
+// Same as FindCvtermIdByAccession, however aborts on cancellation of the
+// context
+func (helper *ChadoHelper) FindCvtermIdByAccessionContext(ctx context.Context, dbxref string) (int, error) {
+    if !strings.Contains(dbxref, ":") {
+        return 0, fmt.Errorf("dbxref %s is not in Db:Accession form", dbxref)
+    }
+    db, accession := splitDbxref(dbxref)
+    ids, err := helper.FindCvtermIdsByAccessionContext(ctx, db, []string{accession})
     if err != nil {
-        _ = tx.Rollback()
-        return 0, fmt.Errorf("error %s in commiting record ", err)
+        return 0, err
+    }
+    id, ok := ids[accession]
+    if !ok {
+        return 0, sql.ErrNoRows
     }
-    id := int(id64)
-    dbcache.Set(db, id)
     return id, nil
 }
 
-// Given a cv namespace returns its primary key identifier. The lookup is done on
-// the cache first and if absent retrieved from cv table.
-func (helper *ChadoHelper) FindOrCreateCvId(cv string) (int, error) {
-    cvcache := helper.caches["cv"]
-    if cvcache.Has(cv) {
-        return cvcache.Get(cv), nil
+// Given a db and accessions returns the primary key identifiers of their
+// cvterms keyed by accession, the absent ones are left out. The accessions
+// absent in the cache are retrieved through a single query for every batch
+// of them and cached along with their dbxrefs.
+func (helper *ChadoHelper) FindCvtermIdsByAccession(db string, accessions []string) (map[string]int, error) {
+    return helper.FindCvtermIdsByAccessionContext(context.Background(), db, accessions)
+}
+
+// Same as FindCvtermIdsByAccession, however aborts on cancellation of the
+// context
+func (helper *ChadoHelper) FindCvtermIdsByAccessionContext(ctx context.Context, db string, accessions []string) (map[string]int, error) {
+    acache := helper.caches["accession"]
+    ids := make(map[string]int)
+    absent := make([]interface{}, 0)
+    for _, acc := range accessions {
+        if id, ok := acache.Lookup(db + ":" + acc); ok {
+            ids[acc] = id
+            continue
+        }
+        absent = append(absent, acc)
     }
-    sqlx := helper.Database.ChadoHandler
-    q := "SELECT cv_id FROM cv WHERE name = $1"
-    row := sqlx.QueryRowx(q, cv)
-    var cvid int
-    _ = row.Scan(&cvid)
-    if cvid != 0 {
-        cvcache.Set(cv, cvid)
-        return cvid, nil
-    }
-
-    tx := sqlx.MustBegin()
-    result := tx.Execl("INSERT INTO cv(name) VALUES($1)", cv)
-    id64, err := result.LastInsertId()
+    dbh := helper.Database.ChadoHandler
+    // keeps the bound parameters below the limit of sqlite
+    const size = 900
+    for start := 0; start < len(absent); start += size {
+        end := start + size
+        if end > len(absent) {
+            end = len(absent)
+        }
+        q := fmt.Sprintf(
+            "%s AND db.name = ? AND dbxref.accession IN (%s)",
+            cvtermRowQuery, strings.TrimSuffix(strings.Repeat("?,", end-start), ","),
+        )
+        var rows []cvtermRow
+        args := append([]interface{}{db}, absent[start:end]...)
+        if err := dbh.SelectContext(ctx, &rows, dbh.Rebind(q), args...); err != nil {
+            return nil, fmt.Errorf("error %s in retrieving cvterms of db %s", err, db)
+        }
+        for _, r := range rows {
+            helper.cacheCvterm(r)
+        }
+    }
+    for _, acc := range absent {
+        if id, ok := acache.Lookup(db + ":" + acc.(string)); ok {
+            ids[acc.(string)] = id
+        }
+    }
+    return ids, nil
+}
+
+// Given a db name, accession and version returns the primary key identifier
+// of dbxref. The db is created if absent. The lookup is done on the cache
+// first and if absent retrieved from dbxref table by its unique key.
+func (helper *ChadoHelper) FindOrCreateDbxrefId(db, accession, version string) (int, error) {
+    return helper.FindOrCreateDbxrefIdContext(context.Background(), db, accession, version)
+}
+
+// Same as FindOrCreateDbxrefId, however aborts on cancellation of the context
+func (helper *ChadoHelper) FindOrCreateDbxrefIdContext(ctx context.Context, db, accession, version string) (int, error) {
+    xcache := helper.caches["dbxref"]
+    key := db + "-" + accession + "-" + version
+    if id, ok := xcache.Lookup(key); ok {
+        return id, nil
+    }
+    return helper.flight.Do(ctx, "dbxref-"+key, func() (int, error) {
+        dbid, err := helper.FindOrCreateDbIdContext(ctx, db)
+        if err != nil {
+            return 0, err
+        }
+        id, err := helper.findOrInsert(
+            ctx, "dbxref", 3,
+            []string{"db_id", "accession", "version"},
+            []interface{}{dbid, accession, version},
+        )
+        if err != nil {
+            return 0, fmt.Errorf("error %s in retreiving dbxref_id", err)
+        }
+        xcache.Set(key, id)
+        return id, nil
+    })
+}
+
+// Given cvterm, cv, dbxref and db parameters returns the primary key
+// identifier of cvterm. The parameters are same as of CreateCvtermId. The
+// cvterm is looked up by its cv and name, it is created along with its cv,
+// db and dbxref if absent. Returns *ConflictError if it exists with another
+// dbxref, the dbxref is created only along with the cvterm.
+func (helper *ChadoHelper) FindOrCreateCvtermId(params map[string]string) (int, error) {
+    return helper.FindOrCreateCvtermIdContext(context.Background(), params)
+}
+
+// Same as FindOrCreateCvtermId, however aborts on cancellation of the context
+func (helper *ChadoHelper) FindOrCreateCvtermIdContext(ctx context.Context, params map[string]string) (int, error) {
+    for _, k := range []string{"cv", "cvterm", "dbxref"} {
+        if _, ok := params[k]; !ok {
+            return 0, fmt.Errorf("missing key %s", k)
+        }
+    }
+    cvtcache := helper.caches["cvterm"]
+    key := params["cv"] + "-" + params["cvterm"]
+    if id, ok := cvtcache.Lookup(key); ok {
+        return id, nil
+    }
+    return helper.flight.Do(ctx, "cvterm-"+key, func() (int, error) {
+        db, xref := splitDbxref(params["dbxref"])
+        if v, ok := params["db"]; ok {
+            db = v
+        }
+        cvid, err := helper.FindOrCreateCvIdContext(ctx, params["cv"])
+        if err != nil {
+            return 0, fmt.Errorf("error %s with FindOrCreateCvId()", err)
+        }
+        // the unique key of cvterm does not include its dbxref, so the
+        // existing one is looked up before creating any dbxref
+        dbh := helper.Database.ChadoHandler
+        var existing cvtermRow
+        err = dbh.GetContext(
+            ctx, &existing,
+            dbh.Rebind(cvtermRowQuery+" AND cvterm.cv_id = ? AND cvterm.name = ?"),
+            cvid, params["cvterm"],
+        )
+        switch {
+        case err == nil:
+            if existing.Db != db || existing.Accession != xref {
+                return 0, &ConflictError{
+                    Table:    "cvterm",
+                    Key:      params["cv"] + ":" + params["cvterm"],
+                    Column:   "dbxref",
+                    Existing: existing.Db + ":" + existing.Accession,
+                    Expected: db + ":" + xref,
+                }
+            }
+            cvtcache.Set(key, existing.CvtermId)
+            return existing.CvtermId, nil
+        case err != sql.ErrNoRows:
+            return 0, fmt.Errorf("error %s in retreiving cvterm_id", err)
+        }
+        dbxrefid, err := helper.FindOrCreateDbxrefIdContext(ctx, db, xref, "")
+        if err != nil {
+            return 0, fmt.Errorf("error %s with FindOrCreateDbxrefId()", err)
+        }
+        id, err := helper.findOrInsert(
+            ctx, "cvterm", 3,
+            []string{"cv_id", "name", "is_obsolete", "dbxref_id"},
+            []interface{}{cvid, params["cvterm"], 0, dbxrefid},
+        )
+        if err != nil {
+            return 0, fmt.Errorf("error %s in retreiving cvterm_id", err)
+        }
+        cvtcache.Set(key, id)
+        return id, nil
+    })
+}
+
+// Inserts a row and returns its primary key, the table name with _id suffix.
+// The key is retrieved through INSERT ... RETURNING on postgres as its driver
+// do not support LastInsertId, and through LastInsertId on the rest.
+func insertId(ctx context.Context, h Handle, table string, columns []string, values ...interface{}) (int, error) {
+    pk := table + "_id"
+    q := fmt.Sprintf(
+        "INSERT INTO %s(%s) VALUES(%s)",
+        table, strings.Join(columns, ","),
+        strings.TrimSuffix(strings.Repeat("?,", len(columns)), ","),
+    )
+    if DialectFor(h) == "postgres" {
+        var id int
+        err := h.QueryRowxContext(ctx, h.Rebind(q+" RETURNING "+pk), values...).Scan(&id)
+        return id, err
+    }
+    res, err := h.ExecContext(ctx, h.Rebind(q), values...)
     if err != nil {
-        _ = tx.Rollback()
         return 0, err
     }
-    err = tx.Commit()
+    id64, err := res.LastInsertId()
     if err != nil {
-        _ = tx.Rollback()
         return 0, err
     }
-    id := int(id64)
-    cvcache.Set(cv, id)
-    return id, nil
+    return int(id64), nil
+}
+
+// Splits dbxref of Db:Id form, the *internal* db is returned for the one
+// without db
+func splitDbxref(dbxref string) (string, string) {
+    if strings.Contains(dbxref, ":") {
+        d := strings.SplitN(dbxref, ":", 2)
+        return d[0], d[1]
+    }
+    return "internal", dbxref
+}
+
+// Error for an existing row that shares the unique key of the one being
+// created, however differs in another column
+type ConflictError struct {
+    Table string
+    // value of the unique key
+    Key    string
+    Column string
+    // values of the column in the existing and the new row
+    Existing interface{}
+    Expected interface{}
+}
+
+func (e *ConflictError) Error() string {
+    return fmt.Sprintf("%s %s exists with %s %v instead of %v", e.Table, e.Key, e.Column, e.Existing, e.Expected)
+}
+
+// Inserts a row with the values of columns unless one exists with the
+// values of its first nkey columns and returns its primary key. It is safe to
+// run concurrently, the insert is ignored on a unique conflict through ON
+// CONFLICT DO NOTHING and the existing row is looked up afterwards. The
+// violation of any other constraint is returned as error.
+func (helper *ChadoHelper) findOrInsert(ctx context.Context, table string, nkey int, columns []string, values []interface{}) (int, error) {
+    id, _, err := helper.findOrInsertWith(ctx, helper.Database.ChadoHandler, table, nkey, columns, values)
+    return id, err
+}
+
+// Same as findOrInsert, however runs with a database or transaction handle
+// and reports whether the row is inserted by this call
+func (helper *ChadoHelper) findOrInsertWith(ctx context.Context, h Handle, table string, nkey int, columns []string, values []interface{}) (int, bool, error) {
+    pk := table + "_id"
+    where := make([]string, nkey)
+    for i, c := range columns[:nkey] {
+        where[i] = c + " = ?"
+    }
+    sq := h.Rebind(fmt.Sprintf("SELECT %s FROM %s WHERE %s", pk, table, strings.Join(where, " AND ")))
+    var id int
+    err := h.QueryRowxContext(ctx, sq, values[:nkey]...).Scan(&id)
+    if err == nil {
+        return id, false, nil
+    }
+    if err != sql.ErrNoRows {
+        return 0, false, err
+    }
+    q := fmt.Sprintf(
+        "INSERT INTO %s(%s) VALUES(%s) ON CONFLICT DO NOTHING",
+        table, strings.Join(columns, ","),
+        strings.TrimSuffix(strings.Repeat("?,", len(columns)), ","),
+    )
+    if DialectFor(h) == "postgres" {
+        err := h.QueryRowxContext(ctx, h.Rebind(q+" RETURNING "+pk), values...).Scan(&id)
+        switch {
+        case err == nil:
+            helper.logger.Debug("created "+table, "values", values, pk, id)
+            return id, true, nil
+        case err != sql.ErrNoRows:
+            return 0, false, err
+        }
+    } else {
+        res, err := h.ExecContext(ctx, h.Rebind(q), values...)
+        if err != nil {
+            return 0, false, err
+        }
+        if n, err := res.RowsAffected(); err == nil && n == 1 {
+            if id64, err := res.LastInsertId(); err == nil {
+                helper.logger.Debug("created "+table, "values", values, pk, id64)
+                return int(id64), true, nil
+            }
+        }
+    }
+    // inserted by someone else in the meantime or conflicts with another
+    // unique key
+    if err := h.QueryRowxContext(ctx, sq, values[:nkey]...).Scan(&id); err != nil {
+        return 0, false, err
+    }
+    return id, false, nil
 }
 
 func (helper *ChadoHelper) FindCvtermId(cv, cvt string) (int, error) {
+    return helper.FindCvtermIdContext(context.Background(), cv, cvt)
+}
+
+// Same as FindCvtermId, however aborts on cancellation of the context
+func (helper *ChadoHelper) FindCvtermIdContext(ctx context.Context, cv, cvt string) (int, error) {
     cvtcache := helper.caches["cvterm"]
     cvterm := cv + "-" + cvt
-    if cvtcache.Has(cvterm) {
-        return cvtcache.Get(cvterm), nil
+    if id, ok := cvtcache.Lookup(cvterm); ok {
+        return id, nil
     }
     sqlx := helper.Database.ChadoHandler
     q := `
     SELECT cvterm_id FROM cvterm JOIN cv ON cv.cv_id = cvterm.cv_id
     WHERE cv.name = $1 AND cvterm.name = $2
     `
-    row := sqlx.QueryRowx(q, cv, cvt)
+    row := sqlx.QueryRowxContext(ctx, q, cv, cvt)
     var cvtid int
     err := row.Scan(&cvtid)
     if err != nil {
@@ -198,57 +708,51 @@ func (helper *ChadoHelper) FindCvtermId(cv, cvt string) (int, error) {
 //          storing
 //  db:     optional. By default internal is used.
 func (helper *ChadoHelper) CreateCvtermId(params map[string]string) (int, error) {
+    return helper.CreateCvtermIdContext(context.Background(), params)
+}
+
+// Same as CreateCvtermId, however aborts on cancellation of the context and
+// rolls back the inserts
+func (helper *ChadoHelper) CreateCvtermIdContext(ctx context.Context, params map[string]string) (int, error) {
     for _, k := range []string{"cv", "cvterm", "dbxref"} {
         if _, ok := params[k]; !ok {
             return 0, fmt.Errorf("missing key %s", k)
         }
     }
-    var db string
-    var xref string
-    if strings.Contains(params["dbxref"], ":") {
-        d := strings.SplitN(params["dbxref"], ":", 2)
-        db = d[0]
-        xref = d[1]
-
-    } else {
-        xref = params["dbxref"]
-        db = "internal"
-    }
+    db, xref := splitDbxref(params["dbxref"])
     if v, ok := params["db"]; ok {
         db = v
     }
     sqlx := helper.Database.ChadoHandler
     //create cvterm
-    dbid, err := helper.FindOrCreateDbId(db)
+    dbid, err := helper.FindOrCreateDbIdContext(ctx, db)
     if err != nil {
         return 0, fmt.Errorf("error %s with FindOrCreateDbId()", err)
     }
-    cvid, err := helper.FindOrCreateCvId(params["cv"])
+    cvid, err := helper.FindOrCreateCvIdContext(ctx, params["cv"])
     if err != nil {
         return 0, fmt.Errorf("error %s with FindOrCreateCvId()", err)
     }
-    tx := sqlx.MustBegin()
-    result := tx.Execl("INSERT INTO dbxref(db_id,accession) VALUES($1, $2)", dbid, xref)
-    dbxrefid, err := result.LastInsertId()
+    tx, err := sqlx.BeginTxx(ctx, nil)
     if err != nil {
-        _ = tx.Rollback()
-        return 0, fmt.Errorf("error %s with retreiving dbid", err)
+        return 0, err
     }
-
-    result = tx.Execl("INSERT INTO cvterm(cv_id,name,dbxref_id) VALUES($1, $2,$3)", cvid, params["cvterm"], dbxrefid)
-    id64, err := result.LastInsertId()
+    defer tx.Rollback()
+    dbxrefid, err := insertId(ctx, tx, "dbxref", []string{"db_id", "accession"}, dbid, xref)
+    if err != nil {
+        return 0, fmt.Errorf("error %s with inserting dbxref", err)
+    }
+    id, err := insertId(ctx, tx, "cvterm", []string{"cv_id", "name", "dbxref_id"}, cvid, params["cvterm"], dbxrefid)
     if err != nil {
-        _ = tx.Rollback()
-        return 0, fmt.Errorf("error %s with retreiving cvtermid", err)
+        return 0, fmt.Errorf("error %s with inserting cvterm", err)
     }
     err = tx.Commit()
     if err != nil {
-        _ = tx.Rollback()
         return 0, fmt.Errorf("error %s with commiting", err)
     }
-    id := int(id64)
     cvtcache := helper.caches["cvterm"]
     cvtcache.Set(params["cv"]+"-"+params["cvterm"], id)
+    helper.logger.Debug("created cvterm", "cv", params["cv"], "cvterm", params["cvterm"], "cvterm_id", id)
     return id, nil
 }
 
@@ -271,137 +775,117 @@ func (helper *ChadoHelper) NormaLizeId(dbxref string) (int, string, error) {
     return dbid, dbxref, nil
 }
 
-// Parsing sql statements from ini style config file. Each ini section expects
-// to have a sql statement
-/*
-In caboose.ini file
-
-[create_bag]
-CREATE TABLE bag (
-    id INTEGER PRIMARY KEY NOT NULL,
-    name TEXT
-);
-
-[select_bag]
-SELECT id FROM bag WHERE name = ?
-
-[insert_bag]
-INSERT INTO bag(name) VALUES(?)
-
-.......
-
+// Interface for a bucket of rows whose values could be retrieved in the order
+// of its columns. It allows buckets with different row types to be handled
+// together.
+type RowBucket interface {
+    // Number of rows in the bucket
+    Count() int
+    // Removes all rows
+    Clear()
+    // List of column names
+    Columns() []string
+    // Values of the row at pos in the order of columns
+    Values(pos int) []interface{}
+}
 
-   parser := NewSqlParserFromFile("caboose.ini")
-   for _, section := range parser.Sections() {
-       fmt.Printf("section:%s\nvalue:%s\n\n",section,parser.GetSection(section))
-   }
+// A simple way to hold bucket of data primarilly for inserting in batch to a relational backend.
+// It is a simple slice container of typed rows. The row type is expected to
+// be a struct whose exported fields are mapped to database columns through
+// the *db* struct tag, fields without the tag or tagged with "-" are ignored.
+// Ultimately, each of the struct will be transformed into a row in the
+// database.
+/*
+    type Bag struct {
+        Id   int64  `db:"id"`
+        Name string `db:"name"`
+    }
 
+    b := NewDataBucket[Bag]()
+    b.Push(Bag{Id: 1, Name: "caboose"})
+    b.Columns()   // []string{"id", "name"}
+    b.Values(0)   // []interface{}{int64(1), "caboose"}
 */
-type SqlParser struct {
-    content map[string]string
+type DataBucket[T any] struct {
+    bucket []T
+    *rowType
 }
 
-// Parse ini sql content from a string and returns a new instance
-func NewSqlParserFromString(content string) *SqlParser {
-    buffer := bytes.NewBufferString(content)
-    return &SqlParser{content: ParseConfig(buffer)}
-}
-
-// Parse ini sql content from a file and returns a new instance
-func NewSqlParserFromFile(file string) *SqlParser {
-    c, err := ioutil.ReadFile(file)
+func NewDataBucket[T any]() *DataBucket[T] {
+    rt, err := lookupRowType(reflect.TypeOf((*T)(nil)).Elem())
     if err != nil {
-        log.Fatal(err)
+        Fatal(DefaultLogger(), "invalid row type of data bucket", "error", err)
     }
-    buffer := bytes.NewBuffer(c)
-    return &SqlParser{content: ParseConfig(buffer)}
-
+    return &DataBucket[T]{bucket: make([]T, 0), rowType: rt}
 }
 
-// Parse ini content from a buffer
-func ParseConfig(buffer *bytes.Buffer) map[string]string {
-    var curr string
-    var b bytes.Buffer
-    content := make(map[string]string)
-
-    for {
-        line, err := buffer.ReadString('\n')
-        if err == io.EOF {
-            content[curr] = b.String() + line
-            break
-        }
-        if strings.HasPrefix(line, "\n") {
-            continue
-        }
-        // skip comment
-        if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
-            continue
-        }
-        if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]\n") {
-            key := line[1 : len(line)-2]
-            if _, ok := content[key]; ok {
-                log.Fatal("duplicate keys not allowed")
-            } else {
-                if len(curr) == 0 { //first block
-                    curr = key
-                } else {
-                    content[curr] = b.String()
-                    curr = key
-                    b.Reset()
-                }
-            }
-        } else {
-            b.WriteString(line)
-        }
-    }
-    return content
+func (b *DataBucket[T]) Push(row T) {
+    b.bucket = append(b.bucket, row)
 }
 
-// List of ini section
-func (ini *SqlParser) Sections() []string {
-    var s []string
-    for k, _ := range ini.content {
-        s = append(s, k)
-    }
-    return s
+func (b *DataBucket[T]) Clear() {
+    b.bucket = make([]T, 0)
 }
 
-// Value of a particular section
-func (ini *SqlParser) GetSection(key string) string {
-    if _, ok := ini.content[key]; ok {
-        return ini.content[key]
-    }
-    return ""
+func (b *DataBucket[T]) GetByPosition(pos int) T {
+    return b.bucket[pos]
 }
 
-// A simple way to hold bucket of data primarilly for inserting in batch to a relational backend.
-// It is a simple slice of maps container where each will have values keyed by
-// a column name. Ultimately, each of this map will be transformed into a row
-// in the database.
-type DataBucket struct {
-    bucket []map[string]interface{}
+func (b *DataBucket[T]) Elements() []T {
+    return b.bucket
 }
 
-func NewDataBucket() *DataBucket {
-    return &DataBucket{bucket: make([]map[string]interface{}, 0)}
+func (b *DataBucket[T]) Count() int {
+    return len(b.bucket)
 }
 
-func (b *DataBucket) Push(m map[string]interface{}) {
-    b.bucket = append(b.bucket, m)
+// List of column names in the order of struct fields
+func (b *DataBucket[T]) Columns() []string {
+    return b.columns
 }
 
-func (b *DataBucket) Clear() {
-    b.bucket = make([]map[string]interface{}, 0)
+// Values of the row at pos in the order of columns
+func (b *DataBucket[T]) Values(pos int) []interface{} {
+    rv := reflect.ValueOf(b.bucket[pos])
+    values := make([]interface{}, len(b.fields))
+    for i, idx := range b.fields {
+        values[i] = rv.Field(idx).Interface()
+    }
+    return values
 }
 
-func (b *DataBucket) GetByPosition(pos int) map[string]interface{} {
-    return b.bucket[pos]
+// Column mapping of a struct type
+type rowType struct {
+    // column names
+    columns []string
+    // index of struct field for each of the column
+    fields []int
 }
 
-func (b *DataBucket) Elements() []map[string]interface{} {
-    return b.bucket
-}
+// Cache of column mapping keyed by struct type, so that the reflection is
+// done only once for a type
+var rowTypes sync.Map
 
-func (b *DataBucket) Count() int {
-    return len(b.bucket)
+func lookupRowType(t reflect.Type) (*rowType, error) {
+    if rt, ok := rowTypes.Load(t); ok {
+        return rt.(*rowType), nil
+    }
+    if t.Kind() != reflect.Struct {
+        return nil, fmt.Errorf("row type %s is not a struct", t)
+    }
+    rt := &rowType{columns: make([]string, 0), fields: make([]int, 0)}
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        col := f.Tag.Get("db")
+        if len(f.PkgPath) != 0 || len(col) == 0 || col == "-" {
+            continue
+        }
+        rt.columns = append(rt.columns, col)
+        rt.fields = append(rt.fields, i)
+    }
+    if len(rt.columns) == 0 {
+        return nil, fmt.Errorf("row type %s has no field with db tag", t)
+    }
+    rowTypes.Store(t, rt)
+    return rt, nil
 }
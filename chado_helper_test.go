@@ -1,95 +1,89 @@
 package gochado
 
 import (
+    "context"
+    "database/sql"
     "github.com/dictybase/testchado"
     . "github.com/dictybase/testchado/matchers"
     . "github.com/onsi/gomega"
+    "os"
+    "reflect"
+    "sync"
     "testing"
+    "time"
 )
 
 func TestFindOrCreateDbId(t *testing.T) {
-    RegisterTestingT(t)
-    chado := testchado.NewDBManager()
-    chado.DeploySchema()
-    _ = chado.LoadDefaultFixture()
-    defer chado.DropSchema()
-
-    helper := NewChadoHelper(chado.DBHandle())
-    dbid, err := helper.FindOrCreateDbId("gochado")
-    if err != nil {
-        t.Error(err)
-    }
-    dbid2, err2 := helper.FindOrCreateDbId("gochado")
-    if err2 != nil {
-        t.Error(err2)
-    }
-    if dbid != dbid2 {
-        t.Error("expected %d got %d", dbid, dbid2)
-    }
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        helper := NewChadoHelper(chado.DBHandle())
+        dbid, err := helper.FindOrCreateDbId("gochado")
+        if err != nil {
+            t.Error(err)
+        }
+        dbid2, err2 := helper.FindOrCreateDbId("gochado")
+        if err2 != nil {
+            t.Error(err2)
+        }
+        if dbid != dbid2 {
+            t.Error("expected %d got %d", dbid, dbid2)
+        }
+    })
 }
 
 func TestFindOrCreateCvId(t *testing.T) {
-    RegisterTestingT(t)
-    chado := testchado.NewDBManager()
-    chado.DeploySchema()
-    _ = chado.LoadDefaultFixture()
-    defer chado.DropSchema()
-
-    helper := NewChadoHelper(chado.DBHandle())
-    cvid, err := helper.FindOrCreateCvId("sequence")
-    if err != nil {
-        t.Errorf("should have found cv sequence error: %s", err)
-    }
-    if cvid != 3 {
-        t.Error("should have matched the cv id")
-    }
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        helper := NewChadoHelper(chado.DBHandle())
+        cvid, err := helper.FindOrCreateCvId("sequence")
+        if err != nil {
+            t.Errorf("should have found cv sequence error: %s", err)
+        }
+        if cvid != 3 {
+            t.Error("should have matched the cv id")
+        }
 
-    cvid2, err2 := helper.FindOrCreateCvId("tc")
-    if err2 != nil {
-        t.Errorf("should have created cv tc error: %s", err2)
-    }
-    if cvid2 != 4 {
-        t.Errorf("Expected cvid 4 got %d", cvid2)
-    }
+        cvid2, err2 := helper.FindOrCreateCvId("tc")
+        if err2 != nil {
+            t.Errorf("should have created cv tc error: %s", err2)
+        }
+        if cvid2 != 4 {
+            t.Errorf("Expected cvid 4 got %d", cvid2)
+        }
+    })
 }
 
 func TestFindOrCreateCvtermId(t *testing.T) {
-    RegisterTestingT(t)
-    chado := testchado.NewDBManager()
-    chado.DeploySchema()
-    _ = chado.LoadDefaultFixture()
-    defer chado.DropSchema()
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        helper := NewChadoHelper(chado.DBHandle())
+        id, err := helper.FindCvtermId("sequence", "gene")
+        if err != nil {
+            t.Errorf("should have found cvterm id: %s", err)
+        }
 
-    helper := NewChadoHelper(chado.DBHandle())
-    id, err := helper.FindCvtermId("sequence", "gene")
-    if err != nil {
-        t.Errorf("should have found cvterm id: %s", err)
-    }
+        id2, err2 := helper.FindCvtermId("sequence", "gene")
+        if id != id2 {
+            t.Errorf("first id %d and second id %d should have matched: %s", id, id2, err2)
+        }
 
-    id2, err2 := helper.FindCvtermId("sequence", "gene")
-    if id != id2 {
-        t.Errorf("first id %d and second id %d should have matched: %s", id, id2, err2)
-    }
-
-    p := make(map[string]string)
-    p["cv"] = "gochado"
-    p["cvterm"] = "gochadoterm"
-    p["dbxref"] = "GC:59939"
-    _, err = helper.CreateCvtermId(p)
-    if err != nil {
-        t.Error(err)
-    }
-    Expect(chado).Should(HaveCvterm(p["cvterm"]))
-    Expect(chado).Should(HaveDbxref(p["dbxref"]))
+        p := make(map[string]string)
+        p["cv"] = "gochado"
+        p["cvterm"] = "gochadoterm"
+        p["dbxref"] = "GC:59939"
+        _, err = helper.CreateCvtermId(p)
+        if err != nil {
+            t.Error(err)
+        }
+        Expect(chado).Should(HaveCvterm(p["cvterm"]))
+        Expect(chado).Should(HaveDbxref(p["dbxref"]))
 
-    p["cv"] = "seinfeld"
-    p["cvterm"] = "The chinese restaurant"
-    p["dbxref"] = "Todd Gag"
-    _, err = helper.CreateCvtermId(p)
-    if err != nil {
-        t.Error(err)
-    }
-    Expect(chado).Should(HaveDbxref("internal:Todd Gag"))
+        p["cv"] = "seinfeld"
+        p["cvterm"] = "The chinese restaurant"
+        p["dbxref"] = "Todd Gag"
+        _, err = helper.CreateCvtermId(p)
+        if err != nil {
+            t.Error(err)
+        }
+        Expect(chado).Should(HaveDbxref("internal:Todd Gag"))
+    })
 }
 
 func TestNormalizeId(t *testing.T) {
@@ -117,3 +111,418 @@ func TestNormalizeId(t *testing.T) {
         t.Errorf("Expected %d Got %d", dbid, dbid2)
     }
 }
+
+func TestDataBucket(t *testing.T) {
+    type bag struct {
+        Id      int64  `db:"id"`
+        Name    string `db:"name"`
+        Ignored string
+        Skipped string `db:"-"`
+    }
+    b := NewDataBucket[bag]()
+    b.Push(bag{Id: 1, Name: "caboose", Ignored: "i", Skipped: "s"})
+    b.Push(bag{Id: 2, Name: "wagon"})
+    if b.Count() != 2 {
+        t.Errorf("expected %d rows got %d", 2, b.Count())
+    }
+    if !reflect.DeepEqual(b.Columns(), []string{"id", "name"}) {
+        t.Errorf("expected columns id and name got %v", b.Columns())
+    }
+    if !reflect.DeepEqual(b.Values(1), []interface{}{int64(2), "wagon"}) {
+        t.Errorf("expected values 2 and wagon got %v", b.Values(1))
+    }
+    if b.GetByPosition(0).Name != "caboose" {
+        t.Errorf("expected %s got %s", "caboose", b.GetByPosition(0).Name)
+    }
+    var rb RowBucket = b
+    rb.Clear()
+    if rb.Count() != 0 {
+        t.Errorf("expected empty bucket got %d rows", rb.Count())
+    }
+}
+
+func TestChadoHelperContext(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        helper := NewChadoHelper(chado.DBHandle())
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        if _, err := helper.FindOrCreateDbIdContext(ctx, "gochado"); err == nil {
+            t.Error("should have failed with cancelled context")
+        }
+        if _, err := helper.FindOrCreateCvIdContext(ctx, "gochado"); err == nil {
+            t.Error("should have failed with cancelled context")
+        }
+        _, err := helper.CreateCvtermIdContext(ctx, map[string]string{
+            "cv":     "gochado",
+            "cvterm": "gochadoterm",
+            "dbxref": "GC:59939",
+        })
+        if err == nil {
+            t.Error("should have failed with cancelled context")
+        }
+        Expect("SELECT COUNT(*) FROM db WHERE name = 'gochado'").Should(HaveCount(0))
+        Expect("SELECT COUNT(*) FROM cv WHERE name = 'gochado'").Should(HaveCount(0))
+
+        dbid, err := helper.FindOrCreateDbIdContext(context.Background(), "gochado")
+        if err != nil {
+            t.Errorf("should have created db gochado error: %s", err)
+        }
+        if dbid == 0 {
+            t.Error("should have returned db id")
+        }
+    })
+}
+
+func TestFindOrCreateConcurrent(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        // two helpers sharing the database, each shared by goroutines
+        helpers := []*ChadoHelper{NewChadoHelper(chado.DBHandle()), NewChadoHelper(chado.DBHandle())}
+        var wg sync.WaitGroup
+        ids := make(chan int, 40)
+        errs := make(chan error, 40)
+        for i := 0; i < 20; i++ {
+            for _, h := range helpers {
+                wg.Add(1)
+                go func(h *ChadoHelper) {
+                    defer wg.Done()
+                    dbid, err := h.FindOrCreateDbId("gochado")
+                    if err != nil {
+                        errs <- err
+                        return
+                    }
+                    if _, err := h.FindOrCreateCvId("gochado"); err != nil {
+                        errs <- err
+                        return
+                    }
+                    ids <- dbid
+                }(h)
+            }
+        }
+        wg.Wait()
+        close(ids)
+        close(errs)
+        for err := range errs {
+            t.Errorf("should not have failed error: %s", err)
+        }
+        var first int
+        for id := range ids {
+            if first == 0 {
+                first = id
+            }
+            if id != first {
+                t.Errorf("expected db id %d got %d", first, id)
+            }
+        }
+        Expect("SELECT COUNT(*) FROM db WHERE name = 'gochado'").Should(HaveCount(1))
+        Expect("SELECT COUNT(*) FROM cv WHERE name = 'gochado'").Should(HaveCount(1))
+    })
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+    g := newFlightGroup()
+    joined := make(chan bool, 1)
+    g.onJoin = func(string) { joined <- true }
+    ctx, cancel := context.WithCancel(context.Background())
+    started := make(chan bool)
+    release := make(chan bool)
+    errs := make(chan error, 1)
+    go func() {
+        _, err := g.Do(ctx, "db-gochado", func() (int, error) {
+            started <- true
+            <-release
+            return 0, ctx.Err()
+        })
+        errs <- err
+    }()
+    <-started
+    ids := make(chan int, 1)
+    go func() {
+        id, err := g.Do(context.Background(), "db-gochado", func() (int, error) {
+            return 7, nil
+        })
+        if err != nil {
+            t.Errorf("waiter should not share the cancellation error: %s", err)
+        }
+        ids <- id
+    }()
+    <-joined
+    cancel()
+    close(release)
+    if err := <-errs; err != context.Canceled {
+        t.Errorf("expected cancellation error got %v", err)
+    }
+    if id := <-ids; id != 7 {
+        t.Errorf("expected id %d from retry got %d", 7, id)
+    }
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+    g := newFlightGroup()
+    joined := make(chan bool, 1)
+    g.onJoin = func(string) { joined <- true }
+    started := make(chan bool)
+    release := make(chan bool)
+    recovered := make(chan interface{}, 1)
+    go func() {
+        defer func() { recovered <- recover() }()
+        g.Do(context.Background(), "db-gochado", func() (int, error) {
+            started <- true
+            <-release
+            panic("driver")
+        })
+    }()
+    <-started
+    errs := make(chan error, 1)
+    go func() {
+        _, err := g.Do(context.Background(), "db-gochado", func() (int, error) {
+            return 7, nil
+        })
+        errs <- err
+    }()
+    <-joined
+    close(release)
+    if r := <-recovered; r != "driver" {
+        t.Errorf("expected the panic to reach its caller got %v", r)
+    }
+    if err := <-errs; err != errFlightPanic {
+        t.Errorf("expected panic error for the waiter got %v", err)
+    }
+    if _, ok := g.calls["db-gochado"]; ok {
+        t.Error("should have removed the call in flight")
+    }
+}
+
+func TestFindOrCreateConstraintError(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        helper := NewChadoHelper(chado.DBHandle())
+        // dbxref with an absent db violates the foreign key, not the unique key
+        _, err := helper.findOrInsert(
+            context.Background(), "dbxref", 3,
+            []string{"db_id", "accession", "version"},
+            []interface{}{nil, "59939", ""},
+        )
+        if err == nil || err == sql.ErrNoRows {
+            t.Errorf("expected constraint error got %v", err)
+        }
+    })
+}
+
+func TestFindOrCreateDbxrefId(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        helper := NewChadoHelper(chado.DBHandle())
+        id, err := helper.FindOrCreateDbxrefId("GC", "59939", "")
+        if err != nil {
+            t.Fatalf("should have created dbxref error: %s", err)
+        }
+        id2, err := NewChadoHelper(chado.DBHandle()).FindOrCreateDbxrefId("GC", "59939", "")
+        if err != nil {
+            t.Fatalf("should have found dbxref error: %s", err)
+        }
+        if id != id2 {
+            t.Errorf("expected dbxref id %d got %d", id, id2)
+        }
+        id3, err := helper.FindOrCreateDbxrefId("GC", "59939", "2")
+        if err != nil {
+            t.Fatalf("should have created dbxref with version error: %s", err)
+        }
+        if id3 == id {
+            t.Error("dbxref with another version should have a different id")
+        }
+        Expect("SELECT COUNT(*) FROM dbxref WHERE accession = '59939'").Should(HaveCount(2))
+    })
+}
+
+func TestFindOrCreateCvtermIdByKey(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        p := map[string]string{
+            "cv":     "gochado",
+            "cvterm": "gochadoterm",
+            "dbxref": "GC:59939",
+        }
+        helper := NewChadoHelper(chado.DBHandle())
+        id, err := helper.FindOrCreateCvtermId(p)
+        if err != nil {
+            t.Fatalf("should have created cvterm error: %s", err)
+        }
+        // a fresh helper has empty caches and looks up the database
+        id2, err := NewChadoHelper(chado.DBHandle()).FindOrCreateCvtermId(p)
+        if err != nil {
+            t.Fatalf("should have found cvterm error: %s", err)
+        }
+        if id != id2 {
+            t.Errorf("expected cvterm id %d got %d", id, id2)
+        }
+        Expect("SELECT COUNT(*) FROM cvterm WHERE name = 'gochadoterm'").Should(HaveCount(1))
+        Expect("SELECT COUNT(*) FROM dbxref WHERE accession = '59939'").Should(HaveCount(1))
+        if _, err := helper.FindOrCreateCvtermId(map[string]string{"cv": "gochado"}); err == nil {
+            t.Error("should have failed for missing keys")
+        }
+        if !helper.caches["dbxref"].Has("GC-59939-") {
+            t.Error("dbxref cache should have been populated")
+        }
+        // same cv and name with another dbxref
+        _, err = NewChadoHelper(chado.DBHandle()).FindOrCreateCvtermId(map[string]string{
+            "cv":     "gochado",
+            "cvterm": "gochadoterm",
+            "dbxref": "GC:59940",
+        })
+        if _, ok := err.(*ConflictError); !ok {
+            t.Errorf("expected conflict error got %v", err)
+        }
+        Expect("SELECT COUNT(*) FROM dbxref WHERE accession = '59940'").Should(HaveCount(0))
+    })
+}
+
+// Runs the test against a fresh database of each backend with the default
+// fixture loaded. Sqlite is always used, postgres only when testchado is
+// configured for it through TC_DSN environment variable.
+func forEachBackend(t *testing.T, fn func(*testing.T, testchado.DBManager)) {
+    backends := map[string]func() testchado.DBManager{
+        "sqlite": testchado.NewSQLiteManager,
+    }
+    if len(os.Getenv("TC_DSN")) != 0 {
+        backends["postgres"] = testchado.NewDBManager
+    }
+    for name, newManager := range backends {
+        t.Run(name, func(t *testing.T) {
+            RegisterTestingT(t)
+            chado := newManager()
+            RegisterDBHandler(chado)
+            chado.DeploySchema()
+            _ = chado.LoadDefaultFixture()
+            defer chado.DropSchema()
+            fn(t, chado)
+        })
+    }
+}
+
+func TestPreloadCv(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        created := make(map[string]int)
+        h := NewChadoHelper(chado.DBHandle())
+        for _, acc := range []string{"0001", "0002", "0003"} {
+            id, err := h.FindOrCreateCvtermId(map[string]string{
+                "cv":     "gochado",
+                "cvterm": "term" + acc,
+                "dbxref": "GC:" + acc,
+            })
+            if err != nil {
+                t.Fatalf("should have created cvterm error: %s", err)
+            }
+            created[acc] = id
+        }
+
+        helper := NewChadoHelper(chado.DBHandle())
+        count, err := helper.PreloadCv("gochado")
+        if err != nil {
+            t.Fatalf("should have preloaded cv error: %s", err)
+        }
+        if count != 3 {
+            t.Errorf("expected %d preloaded cvterms got %d", 3, count)
+        }
+        if !helper.caches["cvterm"].Has("gochado-term0002") {
+            t.Error("cvterm cache should have been warmed")
+        }
+        if !helper.caches["dbxref"].Has("GC-0002-") {
+            t.Error("dbxref cache should have been warmed")
+        }
+        id, err := helper.FindCvtermIdByAccession("GC:0002")
+        if err != nil {
+            t.Errorf("should have found cvterm by accession error: %s", err)
+        }
+        if id != created["0002"] {
+            t.Errorf("expected cvterm id %d got %d", created["0002"], id)
+        }
+        if _, err := helper.FindCvtermIdByAccession("0002"); err == nil {
+            t.Error("should have failed for dbxref without db")
+        }
+
+        ids, err := NewChadoHelper(chado.DBHandle()).FindCvtermIdsByAccession("GC", []string{"0001", "0003", "9999"})
+        if err != nil {
+            t.Fatalf("should have found cvterms by accession error: %s", err)
+        }
+        if len(ids) != 2 {
+            t.Errorf("expected %d cvterms got %d", 2, len(ids))
+        }
+        for _, acc := range []string{"0001", "0003"} {
+            if ids[acc] != created[acc] {
+                t.Errorf("expected cvterm id %d got %d for %s", created[acc], ids[acc], acc)
+            }
+        }
+    })
+}
+
+func TestDataCache(t *testing.T) {
+    dc := NewDataCache()
+    dc.Set("gochado", 1)
+    if !dc.Has("gochado") || dc.Get("gochado") != 1 {
+        t.Error("should have the entry gochado")
+    }
+    if dc.Has("absent") {
+        t.Error("should not have the entry absent")
+    }
+    st := dc.Stats()
+    if st.Hits != 1 || st.Misses != 1 || st.Len != 1 {
+        t.Errorf("unexpected stats %+v", st)
+    }
+    if id, ok := dc.Lookup("gochado"); !ok || id != 1 {
+        t.Errorf("expected entry %d got %d", 1, id)
+    }
+    if _, ok := dc.Lookup("absent"); ok {
+        t.Error("should not look up the entry absent")
+    }
+    if st := dc.Stats(); st.Hits != 2 || st.Misses != 2 {
+        t.Errorf("lookup should count once unexpected stats %+v", st)
+    }
+    dc.Remove("gochado")
+    if dc.Has("gochado") {
+        t.Error("should have removed the entry gochado")
+    }
+
+    lru := NewDataCache(WithCapacity(2))
+    lru.Set("a", 1)
+    lru.Set("b", 2)
+    // a becomes the most recently used, b gets evicted
+    _ = lru.Has("a")
+    lru.Set("c", 3)
+    if lru.Has("b") {
+        t.Error("should have evicted the least recently used entry")
+    }
+    if !lru.Has("a") || !lru.Has("c") {
+        t.Error("should have kept the recently used entries")
+    }
+    if st := lru.Stats(); st.Evictions != 1 || st.Len != 2 {
+        t.Errorf("unexpected stats %+v", st)
+    }
+    lru.Clear()
+    if st := lru.Stats(); st.Len != 0 || st.Hits != 0 || st.Evictions != 0 {
+        t.Errorf("should have cleared the entries and stats got %+v", st)
+    }
+
+    now := time.Now()
+    ttl := NewDataCache(WithTTL(time.Minute))
+    ttl.now = func() time.Time { return now }
+    ttl.Set("a", 1)
+    ttl.Set("b", 2)
+    if !ttl.Has("a") {
+        t.Error("should have the entry before expiry")
+    }
+    now = now.Add(2 * time.Minute)
+    if st := ttl.Stats(); st.Len != 0 {
+        t.Errorf("expired entries should not be counted got %d", st.Len)
+    }
+    if ttl.Has("a") || ttl.Get("a") != 0 {
+        t.Error("should have expired the entry")
+    }
+
+    helper := NewChadoHelper(nil)
+    if err := helper.SetCache("dbxref", lru); err != nil {
+        t.Errorf("should have set dbxref cache error: %s", err)
+    }
+    if helper.Cache("dbxref") != lru {
+        t.Error("should have replaced the dbxref cache")
+    }
+    if err := helper.SetCache("feature", lru); err == nil {
+        t.Error("should not set an unknown cache")
+    }
+}
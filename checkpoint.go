@@ -0,0 +1,83 @@
+package gochado
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "github.com/jmoiron/sqlx"
+    "time"
+)
+
+// Sections of checkpoint.ini that are required for recording checkpoints
+var checkpointSections = []string{
+    "create_table_gochado_checkpoint",
+    "select_checkpoint",
+    "upsert_checkpoint",
+    "delete_checkpoint",
+}
+
+// Records the offset of the last input row that is staged by a run in
+// gochado_checkpoint table, so that a failed run could be resumed from there
+type Checkpoint struct {
+    dbh       *sqlx.DB
+    sqlparser *SqlParser
+}
+
+// Gets a new instance with the embedded sql statements for the dialect of
+// database handle
+func NewCheckpoint(dbh *sqlx.DB) (*Checkpoint, error) {
+    parser, err := SQLFor("checkpoint", DialectFor(dbh))
+    if err != nil {
+        return nil, err
+    }
+    if err := parser.Require(checkpointSections...); err != nil {
+        return nil, err
+    }
+    return &Checkpoint{dbh: dbh, sqlparser: parser}, nil
+}
+
+// Creates the checkpoint table if it is absent
+func (c *Checkpoint) CreateTables() error {
+    _, err := c.dbh.Exec(c.sqlparser.GetSection("create_table_gochado_checkpoint"))
+    if err != nil {
+        return fmt.Errorf("error %s in creating checkpoint table", err)
+    }
+    return nil
+}
+
+// Returns the offset saved for a run of loader, zero if there is none
+func (c *Checkpoint) Offset(run, loader string) (int64, error) {
+    return c.OffsetContext(context.Background(), run, loader)
+}
+
+// Same as Offset, however aborts on cancellation of the context
+func (c *Checkpoint) OffsetContext(ctx context.Context, run, loader string) (int64, error) {
+    var offset int64
+    err := c.dbh.GetContext(ctx, &offset, c.sqlparser.GetSection("select_checkpoint"), run, loader)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, fmt.Errorf("error %s in retrieving checkpoint of run %s", err, run)
+    }
+    return offset, nil
+}
+
+// Saves the offset for a run of loader, it is expected to run in the same
+// transaction that stages the rows
+func (c *Checkpoint) Save(ctx context.Context, h Handle, run, loader string, offset int64) error {
+    _, err := h.ExecContext(ctx, c.sqlparser.GetSection("upsert_checkpoint"), run, loader, offset, time.Now())
+    if err != nil {
+        return fmt.Errorf("error %s in saving checkpoint of run %s", err, run)
+    }
+    return nil
+}
+
+// Removes the checkpoint of a run of loader
+func (c *Checkpoint) Remove(ctx context.Context, h Handle, run, loader string) error {
+    _, err := h.ExecContext(ctx, c.sqlparser.GetSection("delete_checkpoint"), run, loader)
+    if err != nil {
+        return fmt.Errorf("error %s in removing checkpoint of run %s", err, run)
+    }
+    return nil
+}
@@ -0,0 +1,179 @@
+package gochado
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Ways of resolving an identifier to a feature, in the order they are tried
+var resolveMethods = []string{"uniquename", "name", "synonym", "dbxref"}
+
+// Joins and conditions for each of the resolve methods, the dbxref one
+// expects the db and accession of identifier
+var resolveClauses = map[string]string{
+    "uniquename": "WHERE feature.uniquename = ?",
+    "name":       "WHERE feature.name = ?",
+    "synonym": `
+    JOIN feature_synonym ON feature_synonym.feature_id = feature.feature_id
+    JOIN synonym ON synonym.synonym_id = feature_synonym.synonym_id
+    WHERE synonym.name = ?`,
+    "dbxref": `
+    JOIN feature_dbxref ON feature_dbxref.feature_id = feature.feature_id
+    JOIN dbxref ON dbxref.dbxref_id = feature_dbxref.dbxref_id
+    JOIN db ON db.db_id = dbxref.db_id
+    WHERE db.name = ? AND dbxref.accession = ?`,
+}
+
+// Error for an identifier that matches more than one feature
+type AmbiguityError struct {
+    // the identifier and the method it is matched by
+    Id     string
+    Method string
+    // identifiers of the matching features
+    FeatureIds []int
+}
+
+func (e *AmbiguityError) Error() string {
+    return fmt.Sprintf("%s matches %d features by %s", e.Id, len(e.FeatureIds), e.Method)
+}
+
+// Error for an identifier that matches no feature
+var ErrFeatureNotFound = errors.New("feature not found")
+
+// Resolves an identifier to the primary key of feature. The identifier is
+// looked up by uniquename, then name, then synonym and then feature_dbxref
+// if it is in Db:Accession form, the first method that matches is used. The
+// lookup is scoped to an organism and a feature type of sequence ontology
+// unless they are empty. The resolved ones are cached and the ones that
+// match more than one feature are reported.
+/*
+   r := NewFeatureResolver(dbh, &Organism{Genus: "Dictyostelium", Species: "discoideum"}, "gene")
+   id, err := r.Resolve("UniProtKB:Q54J33")
+   for _, a := range r.Ambiguities() {
+       fmt.Println(a)
+   }
+*/
+type FeatureResolver struct {
+    dbh     Handle
+    genus   string
+    species string
+    ftype   string
+    cache   *DataCache
+    // identifiers that match more than one feature
+    ambiguities map[string]*AmbiguityError
+    sync.Mutex
+}
+
+// Gets a new instance, the organism could be nil and the feature type empty
+// for resolving without scope. The handle could be a transaction that the
+// identifiers are resolved within.
+func NewFeatureResolver(dbh Handle, org *Organism, ftype string) *FeatureResolver {
+    r := &FeatureResolver{
+        dbh:         dbh,
+        ftype:       ftype,
+        cache:       NewDataCache(),
+        ambiguities: make(map[string]*AmbiguityError),
+    }
+    if org != nil {
+        r.genus = org.Genus
+        r.species = org.Species
+    }
+    return r
+}
+
+// Replaces the cache of resolved identifiers, for example with a bounded
+// one. It should be called before the resolver is used.
+func (r *FeatureResolver) SetCache(dc *DataCache) {
+    r.cache = dc
+}
+
+// Returns the primary key of feature for an identifier. Returns
+// ErrFeatureNotFound if nothing matches and *AmbiguityError if more than one
+// feature matches.
+func (r *FeatureResolver) Resolve(id string) (int, error) {
+    return r.ResolveContext(context.Background(), id)
+}
+
+// Same as Resolve, however aborts on cancellation of the context
+func (r *FeatureResolver) ResolveContext(ctx context.Context, id string) (int, error) {
+    if fid, ok := r.cache.Lookup(id); ok {
+        return fid, nil
+    }
+    for _, m := range resolveMethods {
+        args := []interface{}{id}
+        if m == "dbxref" {
+            if !strings.Contains(id, ":") {
+                continue
+            }
+            db, acc := splitDbxref(id)
+            args = []interface{}{db, acc}
+        }
+        ids, err := r.lookup(ctx, m, args)
+        if err != nil {
+            return 0, fmt.Errorf("error %s in resolving %s by %s", err, id, m)
+        }
+        switch len(ids) {
+        case 0:
+            continue
+        case 1:
+            r.cache.Set(id, ids[0])
+            return ids[0], nil
+        default:
+            aerr := &AmbiguityError{Id: id, Method: m, FeatureIds: ids}
+            r.Lock()
+            r.ambiguities[id] = aerr
+            r.Unlock()
+            return 0, aerr
+        }
+    }
+    return 0, ErrFeatureNotFound
+}
+
+// Returns the features that match an identifier by a method
+func (r *FeatureResolver) lookup(ctx context.Context, method string, args []interface{}) ([]int, error) {
+    q := "SELECT DISTINCT feature.feature_id FROM feature"
+    scope := make([]string, 0)
+    // is_obsolete is boolean on postgres and integer on sqlite, the bound
+    // value is converted by the driver of either
+    sargs := []interface{}{false}
+    if len(r.genus) != 0 {
+        q += " JOIN organism ON organism.organism_id = feature.organism_id"
+        scope = append(scope, "organism.genus = ?", "organism.species = ?")
+        sargs = append(sargs, r.genus, r.species)
+    }
+    if len(r.ftype) != 0 {
+        q += `
+        JOIN cvterm ftype ON ftype.cvterm_id = feature.type_id
+        JOIN cv ftypecv ON ftypecv.cv_id = ftype.cv_id`
+        scope = append(scope, "ftype.name = ?", "ftypecv.name = 'sequence'")
+        sargs = append(sargs, r.ftype)
+    }
+    q += " " + resolveClauses[method] + " AND feature.is_obsolete = ?"
+    for _, s := range scope {
+        q += " AND " + s
+    }
+    args = append(args, sargs...)
+    var ids []int
+    if err := r.dbh.SelectContext(ctx, &ids, r.dbh.Rebind(q), args...); err != nil && err != sql.ErrNoRows {
+        return nil, err
+    }
+    return ids, nil
+}
+
+// Returns the identifiers that matched more than one feature, sorted by
+// identifier
+func (r *FeatureResolver) Ambiguities() []*AmbiguityError {
+    r.Lock()
+    defer r.Unlock()
+    a := make([]*AmbiguityError, 0, len(r.ambiguities))
+    for _, e := range r.ambiguities {
+        a = append(a, e)
+    }
+    sort.Slice(a, func(i, j int) bool { return a[i].Id < a[j].Id })
+    return a
+}
@@ -0,0 +1,124 @@
+package gochado
+
+import (
+    "github.com/dictybase/testchado"
+    "testing"
+)
+
+func TestFeatureResolver(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        dbh := chado.DBHandle()
+        for _, q := range []string{
+            "INSERT INTO organism(genus, species) VALUES('Dictyostelium', 'purpureum')",
+            "INSERT INTO pub(uniquename, type_id) VALUES('null', 1)",
+            "INSERT INTO db(name) VALUES('UniProtKB')",
+        } {
+            if _, err := dbh.Exec(q); err != nil {
+                t.Fatal(err)
+            }
+        }
+        h := NewChadoHelper(dbh)
+        gene, err := h.FindCvtermId("sequence", "gene")
+        if err != nil {
+            t.Fatal(err)
+        }
+        var ddid, dpid int
+        if err := dbh.Get(&ddid, "SELECT organism_id FROM organism WHERE species = 'discoideum'"); err != nil {
+            t.Fatal(err)
+        }
+        if err := dbh.Get(&dpid, "SELECT organism_id FROM organism WHERE species = 'purpureum'"); err != nil {
+            t.Fatal(err)
+        }
+        features := make(map[string]int)
+        for _, f := range []struct {
+            uniquename, name string
+            organism         int
+            obsolete         bool
+        }{
+            {"DDB_G0272003", "abcA", ddid, false},
+            {"DDB_G0272004", "abcB", ddid, false},
+            {"DPU_G0001", "abcC", dpid, false},
+            {"DDB_G0272005", "abcD", ddid, true},
+        } {
+            _, err := dbh.Exec(
+                dbh.Rebind("INSERT INTO feature(uniquename, name, organism_id, type_id, is_obsolete) VALUES(?, ?, ?, ?, ?)"),
+                f.uniquename, f.name, f.organism, gene, f.obsolete,
+            )
+            if err != nil {
+                t.Fatal(err)
+            }
+            var id int
+            if err := dbh.Get(&id, dbh.Rebind("SELECT feature_id FROM feature WHERE uniquename = ?"), f.uniquename); err != nil {
+                t.Fatal(err)
+            }
+            features[f.uniquename] = id
+        }
+        xref, err := h.FindOrCreateDbxrefId("UniProtKB", "Q54J33", "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, q := range []string{
+            "INSERT INTO synonym(name, type_id) VALUES('shared', 1)",
+            "INSERT INTO synonym(name, type_id) VALUES('sym3', 1)",
+        } {
+            if _, err := dbh.Exec(q); err != nil {
+                t.Fatal(err)
+            }
+        }
+        links := []struct {
+            q    string
+            args []interface{}
+        }{
+            {"INSERT INTO feature_dbxref(feature_id, dbxref_id) VALUES(?, ?)", []interface{}{features["DDB_G0272004"], xref}},
+            {"INSERT INTO feature_synonym(feature_id, synonym_id, pub_id) SELECT ?, synonym_id, 1 FROM synonym WHERE name = 'shared'", []interface{}{features["DDB_G0272003"]}},
+            {"INSERT INTO feature_synonym(feature_id, synonym_id, pub_id) SELECT ?, synonym_id, 1 FROM synonym WHERE name = 'shared'", []interface{}{features["DDB_G0272004"]}},
+            {"INSERT INTO feature_synonym(feature_id, synonym_id, pub_id) SELECT ?, synonym_id, 1 FROM synonym WHERE name = 'sym3'", []interface{}{features["DDB_G0272003"]}},
+        }
+        for _, l := range links {
+            if _, err := dbh.Exec(dbh.Rebind(l.q), l.args...); err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        r := NewFeatureResolver(dbh, &Organism{Genus: "Dictyostelium", Species: "discoideum"}, "gene")
+        for id, expected := range map[string]int{
+            "DDB_G0272003":     features["DDB_G0272003"],
+            "abcB":             features["DDB_G0272004"],
+            "sym3":             features["DDB_G0272003"],
+            "UniProtKB:Q54J33": features["DDB_G0272004"],
+        } {
+            fid, err := r.Resolve(id)
+            if err != nil {
+                t.Errorf("should have resolved %s error: %s", id, err)
+            }
+            if fid != expected {
+                t.Errorf("expected feature %d for %s got %d", expected, id, fid)
+            }
+        }
+        if !r.cache.Has("abcB") {
+            t.Error("should have cached the resolved identifier")
+        }
+        // obsolete features are left out on every backend
+        if _, err := r.Resolve("abcD"); err != ErrFeatureNotFound {
+            t.Errorf("expected not found error for obsolete feature got %v", err)
+        }
+        // out of organism scope
+        if _, err := r.Resolve("DPU_G0001"); err != ErrFeatureNotFound {
+            t.Errorf("expected not found error got %v", err)
+        }
+        if _, err := r.Resolve("shared"); err == nil {
+            t.Error("should have failed for ambiguous identifier")
+        }
+        amb := r.Ambiguities()
+        if len(amb) != 1 {
+            t.Fatalf("expected %d ambiguity got %d", 1, len(amb))
+        }
+        if amb[0].Id != "shared" || amb[0].Method != "synonym" || len(amb[0].FeatureIds) != 2 {
+            t.Errorf("unexpected ambiguity %s", amb[0])
+        }
+        // without scope the other organism is found too
+        if _, err := NewFeatureResolver(dbh, nil, "").Resolve("DPU_G0001"); err != nil {
+            t.Errorf("should have resolved without scope error: %s", err)
+        }
+    })
+}
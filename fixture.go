@@ -3,8 +3,6 @@ package gochado
 import (
     "github.com/dictybase/gorm"
     "github.com/dictybase/testchado"
-    "log"
-    "strings"
 )
 
 type GpadFixtureLoader struct {
@@ -23,7 +21,7 @@ func (f *GpadFixtureLoader) LoadGenes(genes []string) []Feature {
     var cvterm Cvterm
     gorm.Where("name = ?", "gene").First(&cvterm)
     var org Organism
-    gorm.Where("genus = ? AND species = ?", "Dictyostelium", "discoiduem").First(&org)
+    gorm.Where("genus = ? AND species = ?", "Dictyostelium", "discoideum").First(&org)
 
     features := make([]Feature, 0)
     for _, n := range genes {
@@ -45,7 +43,7 @@ func (f *GpadFixtureLoader) LoadGoIds(ids map[string][]string) []Cvterm {
     for id, info := range ids {
         _, xref, err := f.helper.NormaLizeId(id)
         if err != nil {
-            log.Fatal(err)
+            Fatal(f.helper.Logger(), "could not normalize id", "id", id, "error", err)
         }
         var cv Cv
         gorm.Where(&Cv{Name: info[0]}).FirstOrInit(&cv)
@@ -71,21 +69,17 @@ func (f *GpadFixtureLoader) LoadPubIds(ids []string) []Pub {
         "cvterm": "publication",
         "dbxref": "publication",
     }
-    tid, err := h.CreateCvtermId(params)
+    tid, err := h.FindOrCreateCvtermId(params)
     if err != nil {
-        log.Fatal(err)
+        Fatal(h.Logger(), "could not create cvterm", "cvterm", params["cvterm"], "error", err)
+    }
+    refs, err := NewReferenceNormalizer().NormalizeAll(ids)
+    if err != nil {
+        Fatal(h.Logger(), "could not normalize pub ids", "error", err)
     }
-    var pid string
-    pubplace := "GPAD"
     pubs := make([]Pub, 0)
-    for _, id := range ids {
-        pid = id
-        if strings.Contains(id, ":") {
-            out := strings.SplitN(id, ":", 2)
-            pubplace = out[0]
-            pid = out[1]
-        }
-        p := Pub{Uniquename: pid, Pubplace: pubplace, TypeId: int64(tid)}
+    for _, r := range refs {
+        p := Pub{Uniquename: r.Id, Pubplace: r.Pubplace, TypeId: int64(tid)}
         gorm.Save(&p)
         pubs = append(pubs, p)
     }
@@ -97,13 +91,13 @@ func (f *GpadFixtureLoader) LoadMiscCvterms(cv string) []Cvterm {
     gorm := f.gorm
     cvterms := make([]Cvterm, 0)
     for _, cvterm := range []string{"date", "source", "with", "qualifier"} {
-        id, err := h.CreateCvtermId(map[string]string{
+        id, err := h.FindOrCreateCvtermId(map[string]string{
             "cv":     cv,
             "cvterm": cvterm,
             "dbxref": cvterm,
         })
         if err != nil {
-            log.Fatal(err)
+            Fatal(f.helper.Logger(), "could not create cvterm", "cvterm", cvterm, "error", err)
         }
         var t Cvterm
         gorm.Where("cvterm_id = ?", id).First(&t)
@@ -0,0 +1,72 @@
+package gochado
+
+import (
+    "context"
+    "errors"
+    "sync"
+)
+
+// Error shared with the waiters of a lookup that panicked
+var errFlightPanic = errors.New("lookup in flight panicked")
+
+// Deduplicates the concurrent lookups of a key, only the first caller runs
+// the lookup and the rest wait for and share its result. The result of a
+// caller whose context is done is not shared, the waiters run the lookup
+// again with their own context.
+type flightGroup struct {
+    sync.Mutex
+    calls map[string]*flightCall
+    // called whenever a caller joins a lookup in flight, used by tests
+    onJoin func(key string)
+}
+
+// A lookup in flight
+type flightCall struct {
+    sync.WaitGroup
+    id  int
+    err error
+    // the lookup failed along with the context of its caller
+    cancelled bool
+}
+
+func newFlightGroup() *flightGroup {
+    return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (int, error)) (int, error) {
+    for {
+        g.Lock()
+        if c, ok := g.calls[key]; ok {
+            g.Unlock()
+            if g.onJoin != nil {
+                g.onJoin(key)
+            }
+            c.Wait()
+            if c.cancelled && ctx.Err() == nil {
+                continue
+            }
+            return c.id, c.err
+        }
+        c := &flightCall{}
+        c.Add(1)
+        g.calls[key] = c
+        g.Unlock()
+
+        g.call(ctx, key, c, fn)
+        return c.id, c.err
+    }
+}
+
+// Runs the lookup of a call, the call is completed and removed even if the
+// lookup panics so that its waiters are never left blocked
+func (g *flightGroup) call(ctx context.Context, key string, c *flightCall, fn func() (int, error)) {
+    defer func() {
+        g.Lock()
+        delete(g.calls, key)
+        g.Unlock()
+        c.Done()
+    }()
+    c.err = errFlightPanic
+    c.id, c.err = fn()
+    c.cancelled = c.err != nil && ctx.Err() != nil
+}
@@ -0,0 +1,41 @@
+package gochado
+
+import (
+    "log/slog"
+    "os"
+)
+
+// Interface for structured and leveled logging, it is satisfied by
+// *slog.Logger. The args are alternating keys and values that are logged as
+// fields of the message.
+type Logger interface {
+    Debug(msg string, args ...any)
+    Info(msg string, args ...any)
+    Warn(msg string, args ...any)
+    Error(msg string, args ...any)
+}
+
+// Interface for types that log through an injected logger
+type Loggable interface {
+    SetLogger(Logger)
+}
+
+// Logger that writes through the default slog logger at the time of logging
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, args ...any) { slog.Default().Debug(msg, args...) }
+func (defaultLogger) Info(msg string, args ...any)  { slog.Default().Info(msg, args...) }
+func (defaultLogger) Warn(msg string, args ...any)  { slog.Default().Warn(msg, args...) }
+func (defaultLogger) Error(msg string, args ...any) { slog.Default().Error(msg, args...) }
+
+// Logger that is used unless one is set, it writes through slog.Default
+func DefaultLogger() Logger {
+    return defaultLogger{}
+}
+
+// Logs the message at error level and quits the program. It is used by the
+// methods that could not return an error.
+func Fatal(l Logger, msg string, args ...any) {
+    l.Error(msg, args...)
+    os.Exit(1)
+}
@@ -32,6 +32,7 @@ type Cv struct {
     Name       string
     Definition string
     Cvterms    []Cvterm
+    Cvprops    []Cvprop
 }
 
 type Cvterm struct {
@@ -46,6 +47,82 @@ type Cvterm struct {
     Cv       Cv
     DbxrefId int64
     Dbxref   Dbxref
+
+    //has_many relations
+    // Relationships where the term is the subject, i.e. links to its parents
+    Parents []CvtermRelationship `gorm:"foreignkey:SubjectId"`
+    // Relationships where the term is the object, i.e. links to its children
+    Children      []CvtermRelationship `gorm:"foreignkey:ObjectId"`
+    Synonyms      []Cvtermsynonym
+    Cvtermprops   []Cvtermprop
+    CvtermDbxrefs []CvtermDbxref
+}
+
+type CvtermRelationship struct {
+    CvtermRelationshipId int64 `primary_key:"cvterm_relationship_id"`
+    //foreign keys
+    TypeId    int64
+    Type      Cvterm
+    SubjectId int64
+    Subject   Cvterm
+    ObjectId  int64
+    Object    Cvterm
+}
+
+type Cvtermpath struct {
+    CvtermpathId int64 `primary_key:"cvtermpath_id"`
+    Pathdistance int64
+    //foreign keys
+    TypeId    int64
+    Type      Cvterm
+    SubjectId int64
+    Subject   Cvterm
+    ObjectId  int64
+    Object    Cvterm
+    CvId      int64
+    Cv        Cv
+}
+
+type Cvtermsynonym struct {
+    CvtermsynonymId int64 `primary_key:"cvtermsynonym_id"`
+    Synonym         string
+    //foreign keys
+    CvtermId int64
+    Cvterm   Cvterm
+    TypeId   int64
+    Type     Cvterm
+}
+
+type Cvtermprop struct {
+    CvtermpropId int64 `primary_key:"cvtermprop_id"`
+    Value        string
+    Rank         int64
+    //foreign keys
+    CvtermId int64
+    Cvterm   Cvterm
+    TypeId   int64
+    Type     Cvterm
+}
+
+type CvtermDbxref struct {
+    CvtermDbxrefId  int64 `primary_key:"cvterm_dbxref_id"`
+    IsForDefinition bool
+    //foreign keys
+    CvtermId int64
+    Cvterm   Cvterm
+    DbxrefId int64
+    Dbxref   Dbxref
+}
+
+type Cvprop struct {
+    CvpropId int64 `primary_key:"cvprop_id"`
+    Value    string
+    Rank     int64
+    //foreign keys
+    CvId   int64
+    Cv     Cv
+    TypeId int64
+    Type   Cvterm
 }
 
 type Organism struct {
@@ -79,8 +156,13 @@ type Feature struct {
     Type       Cvterm
 
     //has_many relations
-    FeatureCvterms []FeatureCvterm
-    FeatureDbxrefs []FeatureDbxref
+    FeatureCvterms   []FeatureCvterm
+    FeatureDbxrefs   []FeatureDbxref
+    Featurelocs      []Featureloc
+    Featureprops     []Featureprop
+    FeatureSynonyms  []FeatureSynonym
+    FeaturePubs      []FeaturePub
+    Analysisfeatures []Analysisfeature
 }
 
 type Pub struct {
@@ -100,6 +182,54 @@ type Pub struct {
     //foreign key
     TypeId int64
     Type   Cvterm
+    //has_many relations
+    Pubprops   []Pubprop
+    PubDbxrefs []PubDbxref
+    Pubauthors []Pubauthor
+}
+
+type Pubprop struct {
+    PubpropId int64 `primary_key:"pubprop_id"`
+    Value     string
+    Rank      int64
+    //foreign keys
+    PubId  int64
+    Pub    Pub
+    TypeId int64
+    Type   Cvterm
+}
+
+type PubDbxref struct {
+    PubDbxrefId int64 `primary_key:"pub_dbxref_id"`
+    IsCurrent   bool
+    //foreign keys
+    PubId    int64
+    Pub      Pub
+    DbxrefId int64
+    Dbxref   Dbxref
+}
+
+type PubRelationship struct {
+    PubRelationshipId int64 `primary_key:"pub_relationship_id"`
+    //foreign keys
+    SubjectId int64
+    Subject   Pub
+    ObjectId  int64
+    Object    Pub
+    TypeId    int64
+    Type      Cvterm
+}
+
+type Pubauthor struct {
+    PubauthorId int64 `primary_key:"pubauthor_id"`
+    Rank        int64
+    Editor      bool
+    Surname     string
+    Givennames  string
+    Suffix      string
+    //foreign key
+    PubId int64
+    Pub   Pub
 }
 
 type FeatureCvterm struct {
@@ -113,6 +243,11 @@ type FeatureCvterm struct {
     Cvterm    Cvterm
     PubId     int64
     Pub       Pub
+
+    //has_many relations
+    FeatureCvtermprops   []FeatureCvtermprop
+    FeatureCvtermPubs    []FeatureCvtermPub
+    FeatureCvtermDbxrefs []FeatureCvtermDbxref
 }
 
 type FeatureDbxref struct {
@@ -122,3 +257,135 @@ type FeatureDbxref struct {
     Feature         Feature
     Dbxref          Dbxref
 }
+
+type Featureloc struct {
+    FeaturelocId  int64 `primary_key:"featureloc_id"`
+    Fmin          int64
+    IsFminPartial bool
+    Fmax          int64
+    IsFmaxPartial bool
+    Strand        int64
+    Phase         int64
+    ResidueInfo   string
+    Locgroup      int64
+    Rank          int64
+    //foreign keys
+    FeatureId    int64
+    Feature      Feature
+    SrcfeatureId int64
+    Srcfeature   Feature
+}
+
+type FeatureRelationship struct {
+    FeatureRelationshipId int64 `primary_key:"feature_relationship_id"`
+    Value                 string
+    Rank                  int64
+    //foreign keys
+    SubjectId int64
+    Subject   Feature
+    ObjectId  int64
+    Object    Feature
+    TypeId    int64
+    Type      Cvterm
+}
+
+type Featureprop struct {
+    FeaturepropId int64 `primary_key:"featureprop_id"`
+    Value         string
+    Rank          int64
+    //foreign keys
+    FeatureId int64
+    Feature   Feature
+    TypeId    int64
+    Type      Cvterm
+}
+
+type Synonym struct {
+    SynonymId   int64 `primary_key:"synonym_id"`
+    Name        string
+    SynonymSgml string
+    //foreign key
+    TypeId int64
+    Type   Cvterm
+    //has_many relations
+    FeatureSynonyms []FeatureSynonym
+}
+
+type FeatureSynonym struct {
+    FeatureSynonymId int64 `primary_key:"feature_synonym_id"`
+    IsCurrent        bool
+    IsInternal       bool
+    //foreign keys
+    SynonymId int64
+    Synonym   Synonym
+    FeatureId int64
+    Feature   Feature
+    PubId     int64
+    Pub       Pub
+}
+
+type FeaturePub struct {
+    FeaturePubId int64 `primary_key:"feature_pub_id"`
+    //foreign keys
+    FeatureId int64
+    Feature   Feature
+    PubId     int64
+    Pub       Pub
+}
+
+type FeatureCvtermprop struct {
+    FeatureCvtermpropId int64 `primary_key:"feature_cvtermprop_id"`
+    Value               string
+    Rank                int64
+    //foreign keys
+    FeatureCvtermId int64
+    FeatureCvterm   FeatureCvterm
+    TypeId          int64
+    Type            Cvterm
+}
+
+type FeatureCvtermPub struct {
+    FeatureCvtermPubId int64 `primary_key:"feature_cvterm_pub_id"`
+    //foreign keys
+    FeatureCvtermId int64
+    FeatureCvterm   FeatureCvterm
+    PubId           int64
+    Pub             Pub
+}
+
+type FeatureCvtermDbxref struct {
+    FeatureCvtermDbxrefId int64 `primary_key:"feature_cvterm_dbxref_id"`
+    //foreign keys
+    FeatureCvtermId int64
+    FeatureCvterm   FeatureCvterm
+    DbxrefId        int64
+    Dbxref          Dbxref
+}
+
+type Analysis struct {
+    AnalysisId     int64 `primary_key:"analysis_id"`
+    Name           string
+    Description    string
+    Program        string
+    Programversion string
+    Algorithm      string
+    Sourcename     string
+    Sourceversion  string
+    Sourceuri      string
+    Timeexecuted   time.Time
+    //has_many relations
+    Analysisfeatures []Analysisfeature
+}
+
+type Analysisfeature struct {
+    AnalysisfeatureId int64 `primary_key:"analysisfeature_id"`
+    Rawscore          float64
+    Normscore         float64
+    Significance      float64
+    Identity          float64
+    //foreign keys
+    FeatureId  int64
+    Feature    Feature
+    AnalysisId int64
+    Analysis   Analysis
+}
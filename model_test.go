@@ -0,0 +1,81 @@
+package gochado
+
+import (
+    "github.com/dictybase/testchado"
+    "testing"
+)
+
+func TestCvtermRelations(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        gorm := chado.GormHandle()
+        gorm.LogMode(false)
+
+        var cv Cv
+        if err := gorm.Where(&Cv{Name: "relationship"}).First(&cv).Error; err != nil {
+            t.Fatal(err)
+        }
+        var db Db
+        if err := gorm.Where(&Db{Name: "SO"}).First(&db).Error; err != nil {
+            t.Fatal(err)
+        }
+        terms := make(map[string]*Cvterm)
+        for i, n := range []string{"is_a", "exact", "region", "gene"} {
+            term := &Cvterm{
+                Name:   n + "_term",
+                CvId:   cv.CvId,
+                Dbxref: Dbxref{Accession: string(rune('a' + i)), DbId: db.DbId},
+            }
+            if err := gorm.Save(term).Error; err != nil {
+                t.Fatal(err)
+            }
+            terms[n] = term
+        }
+        rel := CvtermRelationship{
+            TypeId:    terms["is_a"].CvtermId,
+            SubjectId: terms["gene"].CvtermId,
+            ObjectId:  terms["region"].CvtermId,
+        }
+        if err := gorm.Save(&rel).Error; err != nil {
+            t.Fatal(err)
+        }
+        syn := Cvtermsynonym{
+            Synonym:  "locus",
+            CvtermId: terms["gene"].CvtermId,
+            TypeId:   terms["exact"].CvtermId,
+        }
+        if err := gorm.Save(&syn).Error; err != nil {
+            t.Fatal(err)
+        }
+
+        var parents []CvtermRelationship
+        gorm.Model(terms["gene"]).Related(&parents, "Parents")
+        if len(parents) != 1 || parents[0].ObjectId != terms["region"].CvtermId {
+            t.Errorf("expected region as the only parent of gene got %v", parents)
+        }
+        var children []CvtermRelationship
+        gorm.Model(terms["region"]).Related(&children, "Children")
+        if len(children) != 1 || children[0].SubjectId != terms["gene"].CvtermId {
+            t.Errorf("expected gene as the only child of region got %v", children)
+        }
+        // both relationships loaded together through their foreign keys
+        var gene, region Cvterm
+        for name, term := range map[string]*Cvterm{"gene": &gene, "region": &region} {
+            err := gorm.Preload("Parents").Preload("Children").
+                Where("cvterm_id = ?", terms[name].CvtermId).First(term).Error
+            if err != nil {
+                t.Fatalf("could not preload relationships of %s error: %s", name, err)
+            }
+        }
+        if len(gene.Parents) != 1 || gene.Parents[0].ObjectId != terms["region"].CvtermId || len(gene.Children) != 0 {
+            t.Errorf("expected region as the only preloaded parent of gene got %v and children %v", gene.Parents, gene.Children)
+        }
+        if len(region.Children) != 1 || region.Children[0].SubjectId != terms["gene"].CvtermId || len(region.Parents) != 0 {
+            t.Errorf("expected gene as the only preloaded child of region got %v and parents %v", region.Children, region.Parents)
+        }
+        var synonyms []Cvtermsynonym
+        gorm.Model(terms["gene"]).Related(&synonyms, "Synonyms")
+        if len(synonyms) != 1 || synonyms[0].Synonym != "locus" {
+            t.Errorf("expected synonym locus for gene got %v", synonyms)
+        }
+    })
+}
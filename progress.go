@@ -0,0 +1,214 @@
+package gochado
+
+import (
+    "bytes"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Interface for receiving the progress of loaders. The methods could be
+// called concurrently by loaders running in parallel.
+type Observer interface {
+    // Number of input lines that are read
+    LinesRead(loader string, n int64)
+    // Number of rows that are inserted in a staging table
+    RowsStaged(loader, table string, n int64)
+    // Number of rows that are inserted in chado by a sql section
+    RowsInserted(loader, section string, n int64)
+    // Time taken by a phase of loader such as staging or chado
+    PhaseDone(loader, phase string, elapsed time.Duration)
+}
+
+// Interface for loaders that report their progress
+type Observable interface {
+    // Sets the observer, a nil value stops the reporting
+    SetObserver(Observer)
+}
+
+// Observer that discards the progress
+type NopObserver struct{}
+
+func (NopObserver) LinesRead(loader string, n int64)                      {}
+func (NopObserver) RowsStaged(loader, table string, n int64)              {}
+func (NopObserver) RowsInserted(loader, section string, n int64)          {}
+func (NopObserver) PhaseDone(loader, phase string, elapsed time.Duration) {}
+
+// Kind of metrics with their names and labels
+type metric struct {
+    name  string
+    help  string
+    kind  string
+    label string
+}
+
+var (
+    linesRead    = metric{"gochado_lines_read_total", "Number of input lines read", "counter", ""}
+    rowsStaged   = metric{"gochado_rows_staged_total", "Number of rows inserted in staging tables", "counter", "table"}
+    rowsInserted = metric{"gochado_rows_inserted_total", "Number of rows inserted in chado by sql sections", "counter", "section"}
+    phaseSeconds = metric{"gochado_phase_seconds", "Time taken by the last run of loader phases", "gauge", "phase"}
+)
+
+// Observer that aggregates the progress as metrics. They could be exposed in
+// Prometheus text format as an http.Handler or logged periodically.
+/*
+   m := gochado.NewMetrics()
+   staging.SetObserver(m)
+   srv := m.Serve("localhost:9100")
+   defer srv.Close()
+   stop := m.LogEvery(slog.Default(), time.Minute)
+   defer stop()
+*/
+type Metrics struct {
+    sync.RWMutex
+    values map[metric]map[[2]string]float64
+}
+
+func NewMetrics() *Metrics {
+    return &Metrics{values: make(map[metric]map[[2]string]float64)}
+}
+
+func (m *Metrics) add(mt metric, loader, label string, v float64) {
+    m.Lock()
+    defer m.Unlock()
+    if _, ok := m.values[mt]; !ok {
+        m.values[mt] = make(map[[2]string]float64)
+    }
+    m.values[mt][[2]string{loader, label}] += v
+}
+
+func (m *Metrics) set(mt metric, loader, label string, v float64) {
+    m.Lock()
+    defer m.Unlock()
+    if _, ok := m.values[mt]; !ok {
+        m.values[mt] = make(map[[2]string]float64)
+    }
+    m.values[mt][[2]string{loader, label}] = v
+}
+
+func (m *Metrics) LinesRead(loader string, n int64) {
+    m.add(linesRead, loader, "", float64(n))
+}
+
+func (m *Metrics) RowsStaged(loader, table string, n int64) {
+    m.add(rowsStaged, loader, table, float64(n))
+}
+
+func (m *Metrics) RowsInserted(loader, section string, n int64) {
+    m.add(rowsInserted, loader, section, float64(n))
+}
+
+func (m *Metrics) PhaseDone(loader, phase string, elapsed time.Duration) {
+    m.set(phaseSeconds, loader, phase, elapsed.Seconds())
+}
+
+// Returns the value of a metric, zero if it is absent
+func (m *Metrics) Value(name, loader, label string) float64 {
+    m.RLock()
+    defer m.RUnlock()
+    for mt, values := range m.values {
+        if mt.name == name {
+            return values[[2]string{loader, label}]
+        }
+    }
+    return 0
+}
+
+// Sorted metrics and keys of their values
+func (m *Metrics) sorted() ([]metric, map[metric][][2]string) {
+    mts := make([]metric, 0)
+    keys := make(map[metric][][2]string)
+    for mt, values := range m.values {
+        mts = append(mts, mt)
+        for k := range values {
+            keys[mt] = append(keys[mt], k)
+        }
+        sort.Slice(keys[mt], func(i, j int) bool {
+            return strings.Join(keys[mt][i][:], "\t") < strings.Join(keys[mt][j][:], "\t")
+        })
+    }
+    sort.Slice(mts, func(i, j int) bool { return mts[i].name < mts[j].name })
+    return mts, keys
+}
+
+// Metrics in Prometheus text exposition format
+func (m *Metrics) String() string {
+    m.RLock()
+    defer m.RUnlock()
+    var b bytes.Buffer
+    mts, keys := m.sorted()
+    for _, mt := range mts {
+        fmt.Fprintf(&b, "# HELP %s %s\n", mt.name, mt.help)
+        fmt.Fprintf(&b, "# TYPE %s %s\n", mt.name, mt.kind)
+        for _, k := range keys[mt] {
+            labels := fmt.Sprintf("loader=%q", k[0])
+            if len(mt.label) != 0 {
+                labels += fmt.Sprintf(",%s=%q", mt.label, k[1])
+            }
+            fmt.Fprintf(&b, "%s{%s} %v\n", mt.name, labels, m.values[mt][k])
+        }
+    }
+    return b.String()
+}
+
+// Serves the metrics in Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    fmt.Fprint(w, m.String())
+}
+
+// Starts a http server on addr that serves the metrics under /metrics path.
+// The server runs in background and should be closed by the caller.
+func (m *Metrics) Serve(addr string) *http.Server {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", m)
+    srv := &http.Server{Addr: addr, Handler: mux}
+    go func() {
+        if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            DefaultLogger().Error("could not serve metrics", "addr", addr, "error", err)
+        }
+    }()
+    return srv
+}
+
+// Logs each of the metrics at info level with its labels and value as fields
+func (m *Metrics) Log(l Logger) {
+    m.RLock()
+    defer m.RUnlock()
+    mts, keys := m.sorted()
+    for _, mt := range mts {
+        for _, k := range keys[mt] {
+            args := []any{"metric", mt.name, "loader", k[0]}
+            if len(mt.label) != 0 {
+                args = append(args, mt.label, k[1])
+            }
+            l.Info("progress", append(args, "value", m.values[mt][k])...)
+        }
+    }
+}
+
+// Logs the metrics at every interval until the returned function is called
+func (m *Metrics) LogEvery(l Logger, interval time.Duration) func() {
+    ticker := time.NewTicker(interval)
+    done := make(chan struct{})
+    go func() {
+        for {
+            select {
+            case <-ticker.C:
+                m.Log(l)
+            case <-done:
+                return
+            }
+        }
+    }()
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            ticker.Stop()
+            close(done)
+        })
+    }
+}
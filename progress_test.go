@@ -0,0 +1,51 @@
+package gochado
+
+import (
+    "bytes"
+    "io/ioutil"
+    "log/slog"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestMetrics(t *testing.T) {
+    m := NewMetrics()
+    var o Observer = m
+    o.LinesRead("gpad", 1)
+    o.LinesRead("gpad", 1)
+    o.RowsStaged("gpad", "temp_gpad", 10)
+    o.RowsInserted("gpad", "insert_feature_cvterm", 8)
+    o.PhaseDone("gpad", "staging", 2*time.Second)
+    if v := m.Value("gochado_lines_read_total", "gpad", ""); v != 2 {
+        t.Errorf("expected %d lines got %v", 2, v)
+    }
+    if v := m.Value("gochado_rows_staged_total", "gpad", "temp_gpad"); v != 10 {
+        t.Errorf("expected %d staged rows got %v", 10, v)
+    }
+
+    rec := httptest.NewRecorder()
+    m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+    body, err := ioutil.ReadAll(rec.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, line := range []string{
+        "# TYPE gochado_lines_read_total counter",
+        `gochado_lines_read_total{loader="gpad"} 2`,
+        `gochado_rows_staged_total{loader="gpad",table="temp_gpad"} 10`,
+        `gochado_rows_inserted_total{loader="gpad",section="insert_feature_cvterm"} 8`,
+        `gochado_phase_seconds{loader="gpad",phase="staging"} 2`,
+    } {
+        if !strings.Contains(string(body), line) {
+            t.Errorf("expected line %s in metrics\n%s", line, body)
+        }
+    }
+
+    var b bytes.Buffer
+    m.Log(slog.New(slog.NewTextHandler(&b, nil)))
+    if !strings.Contains(b.String(), "msg=progress metric=gochado_rows_staged_total loader=gpad table=temp_gpad value=10") {
+        t.Errorf("unexpected log output %s", b.String())
+    }
+}
@@ -0,0 +1,313 @@
+package gochado
+
+import (
+    "bytes"
+    "context"
+    "crypto/md5"
+    "encoding/hex"
+    "fmt"
+    "github.com/jmoiron/sqlx"
+    "io"
+    "os"
+    "time"
+)
+
+// Version of the loaders, recorded with the provenance of every load
+const Version = "0.1.0"
+
+// Sections of provenance.ini that create the provenance tables
+var provenanceTables = []string{
+    "create_table_gochado_load",
+    "create_table_gochado_load_count",
+    "create_table_gochado_load_row",
+}
+
+// Sections of provenance.ini that are required for recording provenance
+// along with provenanceTables
+var provenanceSections = []string{
+    "insert_load",
+    "insert_load_count",
+    "select_load",
+    "select_load_by_row",
+    "select_load_count",
+    "delete_load_row",
+    "delete_load_count",
+    "delete_load",
+}
+
+// Chado tables whose rows are removed while reverting a load, in the order
+// they are deleted. Their primary key is the table name with _id suffix.
+var revertTables = []string{
+    "feature_cvterm_pub",
+    "feature_cvtermprop",
+    "feature_cvterm",
+}
+
+// Reverted table that the rows of revertDependents refer to
+const revertParent = "feature_cvterm"
+
+// Tables with rows that refer to feature_cvterm. The ones that refer to a
+// reverted feature_cvterm are removed before it even when they are not linked
+// to the load, as the foreign key would cascade the delete to them.
+var revertDependents = []string{
+    "feature_cvterm_dbxref",
+    "feature_cvterm_pub",
+    "feature_cvtermprop",
+}
+
+// Provenance of a loader run
+type Load struct {
+    LoadId int64 `db:"load_id"`
+    // Name of loader, for example gpad
+    Loader string `db:"loader"`
+    // Name and MD5 checksum of input file
+    FileName string `db:"file_name"`
+    Checksum string `db:"checksum"`
+    // Version of input format given in its header, for example gpa-version
+    SourceVersion string `db:"source_version"`
+    LoaderVersion string `db:"loader_version"`
+    Timeexecuted  time.Time `db:"timeexecuted"`
+    // Number of loaded rows keyed by target, either a table or table:type
+    Counts map[string]int64 `db:"-"`
+}
+
+// Creates a load from an input file, computes its checksum by streaming the
+// file
+func NewLoad(loader, file string) (*Load, error) {
+    r, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Close()
+    hasher := md5.New()
+    if _, err := io.Copy(hasher, r); err != nil {
+        return nil, err
+    }
+    return &Load{
+        Loader:        loader,
+        FileName:      file,
+        Checksum:      hex.EncodeToString(hasher.Sum(nil)),
+        LoaderVersion: Version,
+        Timeexecuted:  time.Now(),
+        Counts:        make(map[string]int64),
+    }, nil
+}
+
+// Records provenance of loader runs in gochado_load table. Every row loaded in
+// chado by a run is linked to it through gochado_load_row table, so that a
+// load could be audited and reverted. The linking relies on the primary keys
+// being incremental and no concurrent insert in the target tables during a
+// load, the loaders lock the target tables for the duration of their
+// transaction.
+type Provenance struct {
+    dbh       *sqlx.DB
+    sqlparser *SqlParser
+}
+
+// Gets a new instance with the embedded sql statements for the dialect of
+// database handle
+func NewProvenance(dbh *sqlx.DB) (*Provenance, error) {
+    parser, err := SQLFor("provenance", DialectFor(dbh))
+    if err != nil {
+        return nil, err
+    }
+    if err := parser.Require(append(append([]string{}, provenanceTables...), provenanceSections...)...); err != nil {
+        return nil, err
+    }
+    return &Provenance{dbh: dbh, sqlparser: parser}, nil
+}
+
+// Creates the provenance tables if they are absent
+func (p *Provenance) CreateTables() error {
+    for _, s := range provenanceTables {
+        if _, err := p.dbh.Exec(p.sqlparser.GetSection(s)); err != nil {
+            return fmt.Errorf("error %s in running section %s", err, s)
+        }
+    }
+    return nil
+}
+
+// Registers a load and sets its LoadId
+func (p *Provenance) Register(ctx context.Context, h Handle, load *Load) error {
+    var id int64
+    err := h.QueryRowxContext(ctx,
+        p.sqlparser.GetSection("insert_load"),
+        load.Loader, load.FileName, load.Checksum, load.SourceVersion,
+        load.LoaderVersion, load.Timeexecuted,
+    ).Scan(&id)
+    if err != nil {
+        return fmt.Errorf("error %s in registering load of %s", err, load.FileName)
+    }
+    load.LoadId = id
+    return nil
+}
+
+// Links rows of a table, whose primary key is larger than last, to a load
+// and records their count under target. The caller should keep the other
+// writers off the table since last is read, otherwise their rows are linked
+// too.
+func (p *Provenance) LinkRows(ctx context.Context, h Handle, load *Load, target, table, pk string, last int64) error {
+    q := fmt.Sprintf(
+        "INSERT INTO gochado_load_row(load_id, table_name, row_id) SELECT ?, ?, %s FROM %s WHERE %s > ?",
+        pk, table, pk,
+    )
+    res, err := h.ExecContext(ctx, h.Rebind(q), load.LoadId, table, last)
+    if err != nil {
+        return fmt.Errorf("error %s in linking rows of %s", err, table)
+    }
+    count, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    _, err = h.ExecContext(ctx, p.sqlparser.GetSection("insert_load_count"), load.LoadId, target, count)
+    if err != nil {
+        return fmt.Errorf("error %s in recording count of %s", err, target)
+    }
+    if load.Counts == nil {
+        load.Counts = make(map[string]int64)
+    }
+    load.Counts[target] = count
+    return nil
+}
+
+// Retrieves a load along with its counts
+func (p *Provenance) Find(id int64) (*Load, error) {
+    load := &Load{}
+    if err := p.dbh.Get(load, p.sqlparser.GetSection("select_load"), id); err != nil {
+        return nil, fmt.Errorf("error %s in retrieving load %d", err, id)
+    }
+    return load, p.counts(load)
+}
+
+// Retrieves the load that inserted a row of a table
+func (p *Provenance) FindByRow(table string, id int64) (*Load, error) {
+    load := &Load{}
+    if err := p.dbh.Get(load, p.sqlparser.GetSection("select_load_by_row"), table, id); err != nil {
+        return nil, fmt.Errorf("error %s in retrieving load of %s row %d", err, table, id)
+    }
+    return load, p.counts(load)
+}
+
+func (p *Provenance) counts(load *Load) error {
+    type count struct {
+        Target   string
+        RowCount int64 `db:"row_count"`
+    }
+    var c []count
+    if err := p.dbh.Select(&c, p.sqlparser.GetSection("select_load_count"), load.LoadId); err != nil {
+        return fmt.Errorf("error %s in retrieving counts of load %d", err, load.LoadId)
+    }
+    load.Counts = make(map[string]int64)
+    for _, r := range c {
+        load.Counts[r.Target] = r.RowCount
+    }
+    return nil
+}
+
+// Rows removed by reverting a load
+type RevertReport struct {
+    // the reverted load
+    Load *Load
+    // Names of tables in the order the rows are removed
+    Tables []string
+    // Number of removed rows keyed by table
+    Counts map[string]int64
+    // Number of removed rows that are not linked to the load, however refer
+    // to a removed feature_cvterm, keyed by table
+    Dependents map[string]int64
+}
+
+// Summary of the counts, one table per line
+func (r *RevertReport) String() string {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "load %d of %s\n", r.Load.LoadId, r.Load.FileName)
+    for _, t := range r.Tables {
+        fmt.Fprintf(&b, "%s\t%d\n", t, r.Counts[t])
+    }
+    for _, t := range revertDependents {
+        if r.Dependents[t] > 0 {
+            fmt.Fprintf(&b, "%s\t%d\tdependent\n", t, r.Dependents[t])
+        }
+    }
+    return b.String()
+}
+
+// Deletes the feature_cvterm, feature_cvtermprop and feature_cvterm_pub rows
+// that are created by a load along with its provenance in a single
+// transaction. The rows are the ones linked to the load, so the load should
+// have been the only writer of those tables while it ran, any row linked by
+// a concurrent writer is removed too. The rows added later that refer to a
+// removed feature_cvterm are removed as well and reported as dependents.
+func (p *Provenance) Revert(id int64) (*RevertReport, error) {
+    return p.RevertContext(context.Background(), id)
+}
+
+// Same as Revert, however the transaction is rolled back on cancellation of
+// the context
+func (p *Provenance) RevertContext(ctx context.Context, id int64) (*RevertReport, error) {
+    load, err := p.Find(id)
+    if err != nil {
+        return nil, err
+    }
+    tx, err := p.dbh.BeginTxx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+    report := &RevertReport{
+        Load:       load,
+        Tables:     make([]string, 0),
+        Counts:     make(map[string]int64),
+        Dependents: make(map[string]int64),
+    }
+    for _, t := range revertTables {
+        if t == revertParent {
+            if err := p.revertDependents(ctx, tx, id, report); err != nil {
+                return nil, err
+            }
+        }
+        q := fmt.Sprintf(
+            "DELETE FROM %s WHERE %s_id IN (SELECT row_id FROM gochado_load_row WHERE load_id = ? AND table_name = ?)",
+            t, t,
+        )
+        res, err := tx.ExecContext(ctx, tx.Rebind(q), id, t)
+        if err != nil {
+            return nil, fmt.Errorf("error %s in removing rows of %s", err, t)
+        }
+        count, err := res.RowsAffected()
+        if err != nil {
+            return nil, err
+        }
+        report.Tables = append(report.Tables, t)
+        report.Counts[t] = count
+    }
+    for _, s := range []string{"delete_load_row", "delete_load_count", "delete_load"} {
+        if _, err := tx.ExecContext(ctx, p.sqlparser.GetSection(s), id); err != nil {
+            return nil, fmt.Errorf("error %s in running section %s", err, s)
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+    return report, nil
+}
+
+// Removes the rows that refer to the linked feature_cvterm rows of a load
+func (p *Provenance) revertDependents(ctx context.Context, tx *sqlx.Tx, id int64, report *RevertReport) error {
+    for _, t := range revertDependents {
+        q := fmt.Sprintf(
+            "DELETE FROM %s WHERE %s_id IN (SELECT row_id FROM gochado_load_row WHERE load_id = ? AND table_name = ?)",
+            t, revertParent,
+        )
+        res, err := tx.ExecContext(ctx, tx.Rebind(q), id, revertParent)
+        if err != nil {
+            return fmt.Errorf("error %s in removing dependent rows of %s", err, t)
+        }
+        count, err := res.RowsAffected()
+        if err != nil {
+            return err
+        }
+        report.Dependents[t] = count
+    }
+    return nil
+}
@@ -0,0 +1,26 @@
+package gochado
+
+import (
+    "io/ioutil"
+    "testing"
+)
+
+func TestNewLoad(t *testing.T) {
+    l, err := NewLoad("gpad", "data/test.gpad")
+    if err != nil {
+        t.Fatalf("could not create load error: %s", err)
+    }
+    c, err := ioutil.ReadFile("data/test.gpad")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if l.Checksum != GetMD5Hash(string(c)) {
+        t.Errorf("expected checksum %s got %s", GetMD5Hash(string(c)), l.Checksum)
+    }
+    if l.LoaderVersion != Version {
+        t.Errorf("expected loader version %s got %s", Version, l.LoaderVersion)
+    }
+    if _, err := NewLoad("gpad", "data/absent.gpad"); err == nil {
+        t.Error("should have failed for absent file")
+    }
+}
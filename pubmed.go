@@ -0,0 +1,363 @@
+package gochado
+
+import (
+    "context"
+    "database/sql"
+    "encoding/xml"
+    "fmt"
+    "github.com/jmoiron/sqlx"
+    "io"
+    "os"
+    "strings"
+)
+
+// Namespace of publications from PubMed, same as the one staging loaders
+// use for PMID references
+const PubmedPubplace = "PubMed"
+
+// Cv of the pub type and pubprop type cvterms
+const PubCv = "pub"
+
+// Pub type and pubprop type cvterms used by PubmedLoader
+const (
+    PubTypeJournalArticle = "journal_article"
+    PubpropAbstract       = "abstract"
+    PubpropStatus         = "status"
+)
+
+// Dbs of the pub_dbxref entries created by PubmedLoader
+const (
+    DoiDb   = "DOI"
+    PmcidDb = "PMCID"
+)
+
+// Subset of a PubmedArticle element of efetch output
+type pubmedArticle struct {
+    Pmid    string `xml:"MedlineCitation>PMID"`
+    Title   xmlText `xml:"MedlineCitation>Article>ArticleTitle"`
+    Journal string `xml:"MedlineCitation>Article>Journal>Title"`
+    Volume  string `xml:"MedlineCitation>Article>Journal>JournalIssue>Volume"`
+    Issue   string `xml:"MedlineCitation>Article>Journal>JournalIssue>Issue"`
+    Year    string `xml:"MedlineCitation>Article>Journal>JournalIssue>PubDate>Year"`
+    // free text date, for example 1998 Dec-1999 Jan, used in absence of year
+    MedlineDate string `xml:"MedlineCitation>Article>Journal>JournalIssue>PubDate>MedlineDate"`
+    Pages       string `xml:"MedlineCitation>Article>Pagination>MedlinePgn"`
+    Abstract    []abstractText `xml:"MedlineCitation>Article>Abstract>AbstractText"`
+    Authors []struct {
+        LastName       string
+        ForeName       string
+        Initials       string
+        Suffix         string
+        CollectiveName string
+    } `xml:"MedlineCitation>Article>AuthorList>Author"`
+    Status     string `xml:"PubmedData>PublicationStatus"`
+    ArticleIds []struct {
+        IdType string `xml:"IdType,attr"`
+        Value  string `xml:",chardata"`
+    } `xml:"PubmedData>ArticleIdList>ArticleId"`
+}
+
+// Text of an element including the text within its markup, such as the
+// <i>, <sup> and <sub> elements of titles and abstracts
+type xmlText string
+
+func (t *xmlText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    text, err := innerText(d)
+    if err != nil {
+        return err
+    }
+    *t = xmlText(text)
+    return nil
+}
+
+// A section of abstract, with optional label for structured abstracts
+type abstractText struct {
+    Label string
+    Text  string
+}
+
+func (a *abstractText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    for _, attr := range start.Attr {
+        if attr.Name.Local == "Label" {
+            a.Label = attr.Value
+        }
+    }
+    text, err := innerText(d)
+    if err != nil {
+        return err
+    }
+    a.Text = text
+    return nil
+}
+
+// Collects the character data of an element along with its nested elements
+// until the end of element
+func innerText(d *xml.Decoder) (string, error) {
+    var b strings.Builder
+    depth := 0
+    for {
+        t, err := d.Token()
+        if err != nil {
+            return "", err
+        }
+        switch e := t.(type) {
+        case xml.CharData:
+            b.Write(e)
+        case xml.StartElement:
+            depth++
+        case xml.EndElement:
+            if depth == 0 {
+                return b.String(), nil
+            }
+            depth--
+        }
+    }
+}
+
+func (a *pubmedArticle) pyear() string {
+    if len(a.Year) > 0 {
+        return a.Year
+    }
+    if len(a.MedlineDate) >= 4 {
+        return a.MedlineDate[:4]
+    }
+    return ""
+}
+
+func (a *pubmedArticle) abstract() string {
+    parts := make([]string, 0)
+    for _, t := range a.Abstract {
+        text := strings.TrimSpace(t.Text)
+        if len(t.Label) > 0 {
+            text = t.Label + ": " + text
+        }
+        parts = append(parts, text)
+    }
+    return strings.Join(parts, "\n")
+}
+
+// Returns the article id of the given type, empty if absent
+func (a *pubmedArticle) articleId(idtype string) string {
+    for _, id := range a.ArticleIds {
+        if id.IdType == idtype {
+            return strings.TrimSpace(id.Value)
+        }
+    }
+    return ""
+}
+
+// Counts of a PubMed load
+type PubmedReport struct {
+    // Articles read from the input
+    Articles int
+    // Newly created pub entries, the rest are already present
+    Created int
+}
+
+// Loads publications from PubMed XML, the efetch output of pubmed database,
+// into pub, pubauthor, pubprop and pub_dbxref tables. The pub is keyed by the
+// PMID as uniquename with PubMed as pubplace, the existing ones are reused,
+// so loading the same file again adds nothing. The abstract and publication
+// status goes to pubprop, DOI and PMCID to pub_dbxref in the canonical form
+// of ReferenceNormalizer.
+/*
+   l := NewPubmedLoader(dbh)
+   report, err := l.LoadFile("pubmed.xml")
+*/
+type PubmedLoader struct {
+    *Database
+    helper *ChadoHelper
+    // canonicalizes DOI and PMCID the same way as the staging loaders
+    normalizer *ReferenceNormalizer
+}
+
+func NewPubmedLoader(dbh *sqlx.DB) *PubmedLoader {
+    return &PubmedLoader{
+        Database:   &Database{ChadoHandler: dbh},
+        helper:     NewChadoHelper(dbh),
+        normalizer: NewReferenceNormalizer(),
+    }
+}
+
+// Sets the logger, a nil value switches back to the default one
+func (l *PubmedLoader) SetLogger(lg Logger) {
+    l.helper.SetLogger(lg)
+}
+
+func (l *PubmedLoader) LoadFile(file string) (*PubmedReport, error) {
+    r, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Close()
+    return l.Load(r)
+}
+
+func (l *PubmedLoader) Load(r io.Reader) (*PubmedReport, error) {
+    return l.LoadContext(context.Background(), r)
+}
+
+// Same as Load, however aborts on cancellation of the context. Every article
+// is loaded in its own transaction, the ones loaded before the error are
+// kept.
+func (l *PubmedLoader) LoadContext(ctx context.Context, r io.Reader) (*PubmedReport, error) {
+    types, err := l.pubTypes(ctx)
+    if err != nil {
+        return nil, err
+    }
+    report := &PubmedReport{}
+    dec := xml.NewDecoder(r)
+    for {
+        if err := ctx.Err(); err != nil {
+            return report, err
+        }
+        t, err := dec.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return report, err
+        }
+        se, ok := t.(xml.StartElement)
+        if !ok || se.Name.Local != "PubmedArticle" {
+            continue
+        }
+        var a pubmedArticle
+        if err := dec.DecodeElement(&a, &se); err != nil {
+            return report, err
+        }
+        created, err := l.loadArticle(ctx, &a, types)
+        if err != nil {
+            return report, fmt.Errorf("error %s in loading PMID %s", err, a.Pmid)
+        }
+        report.Articles++
+        if created {
+            report.Created++
+        }
+    }
+    l.helper.Logger().Info("loaded pubmed articles", "articles", report.Articles, "created", report.Created)
+    return report, nil
+}
+
+// Looks up the pub and pubprop type cvterms, they are created if absent. The
+// existing ones are used whatever their dbxrefs are.
+func (l *PubmedLoader) pubTypes(ctx context.Context) (map[string]int, error) {
+    types := make(map[string]int)
+    for _, name := range []string{PubTypeJournalArticle, PubpropAbstract, PubpropStatus} {
+        id, err := l.helper.FindCvtermIdContext(ctx, PubCv, name)
+        if err == nil {
+            types[name] = id
+            continue
+        }
+        if err != sql.ErrNoRows {
+            return nil, err
+        }
+        id, err = l.helper.FindOrCreateCvtermIdContext(ctx, map[string]string{
+            "cv":     PubCv,
+            "cvterm": name,
+            "dbxref": name,
+        })
+        if err != nil {
+            return nil, err
+        }
+        types[name] = id
+    }
+    return types, nil
+}
+
+// Loads a single article in a transaction and reports whether its pub is
+// newly created. The pub is looked up by its PMID, a *ConflictError is
+// returned if it exists with another pubplace. The dbxrefs of DOI and PMCID
+// are created beforehand outside of the transaction as they are cached by
+// the helper, they are stored in the canonical form of ReferenceNormalizer.
+func (l *PubmedLoader) loadArticle(ctx context.Context, a *pubmedArticle, types map[string]int) (bool, error) {
+    pmid := strings.TrimSpace(a.Pmid)
+    if len(pmid) == 0 {
+        return false, fmt.Errorf("article without PMID")
+    }
+    h := l.helper
+    xrefs := make([]int, 0)
+    for _, x := range [][]string{{RefDoi, a.articleId("doi")}, {RefPmcid, a.articleId("pmc")}} {
+        if len(x[1]) == 0 {
+            continue
+        }
+        ref, err := l.normalizer.Normalize(x[0] + ":" + x[1])
+        if err != nil {
+            h.Logger().Warn("skipped article id", "pmid", pmid, "error", err)
+            continue
+        }
+        xid, err := h.FindOrCreateDbxrefIdContext(ctx, ref.Pubplace, ref.Id, "")
+        if err != nil {
+            return false, err
+        }
+        xrefs = append(xrefs, xid)
+    }
+    tx, err := l.ChadoHandler.BeginTxx(ctx, nil)
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+    // uniquename is the only unique key of pub
+    pubid, created, err := h.findOrInsertWith(
+        ctx, tx, "pub", 1,
+        []string{"uniquename", "pubplace", "title", "series_name", "volume", "issue", "pyear", "pages", "type_id"},
+        []interface{}{pmid, PubmedPubplace, string(a.Title), a.Journal, a.Volume, a.Issue, a.pyear(), a.Pages, types[PubTypeJournalArticle]},
+    )
+    if err != nil {
+        return false, err
+    }
+    if !created {
+        var pubplace sql.NullString
+        err := tx.GetContext(ctx, &pubplace, tx.Rebind("SELECT pubplace FROM pub WHERE pub_id = ?"), pubid)
+        if err != nil {
+            return false, err
+        }
+        if pubplace.String != PubmedPubplace {
+            return false, &ConflictError{Table: "pub", Key: pmid, Column: "pubplace", Existing: pubplace.String, Expected: PubmedPubplace}
+        }
+    }
+    for i, au := range a.Authors {
+        surname, given := au.LastName, au.ForeName
+        if len(surname) == 0 {
+            surname = au.CollectiveName
+        }
+        if len(given) == 0 {
+            given = au.Initials
+        }
+        _, _, err := h.findOrInsertWith(
+            ctx, tx, "pubauthor", 2,
+            []string{"pub_id", "rank", "surname", "givennames", "suffix"},
+            []interface{}{pubid, i + 1, surname, given, au.Suffix},
+        )
+        if err != nil {
+            return false, err
+        }
+    }
+    for _, p := range [][]string{{PubpropAbstract, a.abstract()}, {PubpropStatus, a.Status}} {
+        if len(p[1]) == 0 {
+            continue
+        }
+        _, _, err := h.findOrInsertWith(
+            ctx, tx, "pubprop", 3,
+            []string{"pub_id", "type_id", "rank", "value"},
+            []interface{}{pubid, types[p[0]], 0, p[1]},
+        )
+        if err != nil {
+            return false, err
+        }
+    }
+    for _, xid := range xrefs {
+        _, _, err := h.findOrInsertWith(
+            ctx, tx, "pub_dbxref", 2,
+            []string{"pub_id", "dbxref_id", "is_current"},
+            []interface{}{pubid, xid, true},
+        )
+        if err != nil {
+            return false, err
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return false, err
+    }
+    return created, nil
+}
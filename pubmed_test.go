@@ -0,0 +1,138 @@
+package gochado
+
+import (
+    "github.com/dictybase/testchado"
+    "strings"
+    "testing"
+)
+
+func TestPubmedLoader(t *testing.T) {
+    forEachBackend(t, func(t *testing.T, chado testchado.DBManager) {
+        dbh := chado.DBHandle()
+        l := NewPubmedLoader(dbh)
+        report, err := l.LoadFile("data/pubmed.xml")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if report.Articles != 3 || report.Created != 3 {
+            t.Errorf("expected 3 articles and 3 created got %+v", report)
+        }
+
+        var pub Pub
+        err = dbh.QueryRowx(
+            dbh.Rebind("SELECT pub_id, title, series_name, pyear, pages FROM pub WHERE uniquename = ? AND pubplace = ?"),
+            "23172289", PubmedPubplace,
+        ).Scan(&pub.PubId, &pub.Title, &pub.SeriesName, &pub.Pyear, &pub.Pages)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if pub.Pyear != "2013" || pub.Pages != "D676-83" || pub.SeriesName != "Nucleic acids research" {
+            t.Errorf("unexpected pub %+v", pub)
+        }
+
+        counts := map[string]int{
+            "pub":        3,
+            "pubauthor":  5,
+            "pubprop":    6,
+            "pub_dbxref": 3,
+        }
+        check := func() {
+            for table, n := range counts {
+                var c int
+                if err := dbh.Get(&c, "SELECT COUNT(*) FROM "+table); err != nil {
+                    t.Fatal(err)
+                }
+                if c != n {
+                    t.Errorf("expected %d rows in %s got %d", n, table, c)
+                }
+            }
+        }
+        check()
+
+        var surname string
+        err = dbh.Get(&surname, "SELECT surname FROM pubauthor JOIN pub ON pub.pub_id = pubauthor.pub_id WHERE pub.uniquename = '15875012' AND rank = 3")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if surname != "Dictyostelium Genome Consortium" {
+            t.Errorf("expected collective name as surname got %s", surname)
+        }
+        var abstract string
+        err = dbh.Get(&abstract, dbh.Rebind(`
+            SELECT pubprop.value FROM pubprop
+            JOIN cvterm ON cvterm.cvterm_id = pubprop.type_id
+            WHERE pubprop.pub_id = ? AND cvterm.name = 'abstract'`), pub.PubId)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if abstract != "BACKGROUND: dictyBase is the model organism database for Dictyostelium discoideum.\nRESULTS: It now hosts the genomes of four Dictyostelids." {
+            t.Errorf("unexpected abstract %q", abstract)
+        }
+        // text within markup is kept
+        var title string
+        err = dbh.Get(&title, "SELECT title FROM pub WHERE uniquename = '20000001'")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if title != "Genome of D. discoideum strain AX4." {
+            t.Errorf("unexpected title %q", title)
+        }
+        err = dbh.Get(&abstract, `
+            SELECT pubprop.value FROM pubprop
+            JOIN pub ON pub.pub_id = pubprop.pub_id
+            JOIN cvterm ON cvterm.cvterm_id = pubprop.type_id
+            WHERE pub.uniquename = '20000001' AND cvterm.name = 'abstract'`)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if abstract != "Ca2+ signalling in Dictyostelium is revisited." {
+            t.Errorf("unexpected abstract %q", abstract)
+        }
+        var pmcid string
+        err = dbh.Get(&pmcid, `
+            SELECT dbxref.accession FROM pub_dbxref
+            JOIN dbxref ON dbxref.dbxref_id = pub_dbxref.dbxref_id
+            JOIN db ON db.db_id = dbxref.db_id
+            WHERE db.name = 'PMCID'`)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if pmcid != "PMC1352341" {
+            t.Errorf("expected PMC1352341 got %s", pmcid)
+        }
+        var doi string
+        err = dbh.Get(&doi, `
+            SELECT dbxref.accession FROM pub_dbxref
+            JOIN dbxref ON dbxref.dbxref_id = pub_dbxref.dbxref_id
+            JOIN db ON db.db_id = dbxref.db_id
+            JOIN pub ON pub.pub_id = pub_dbxref.pub_id
+            WHERE db.name = 'DOI' AND pub.uniquename = '15875012'`)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if doi != "10.1038/nature03481" {
+            t.Errorf("expected lowercased DOI got %s", doi)
+        }
+
+        // loading again is deduplicated
+        report, err = l.LoadFile("data/pubmed.xml")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if report.Articles != 3 || report.Created != 0 {
+            t.Errorf("expected 3 articles and none created got %+v", report)
+        }
+        check()
+
+        // the PMID exists with another pubplace
+        if _, err := dbh.Exec("INSERT INTO pub(uniquename, pubplace, type_id) VALUES('20000002', 'PMID', 1)"); err != nil {
+            t.Fatal(err)
+        }
+        _, err = l.Load(strings.NewReader(`<PubmedArticleSet><PubmedArticle>
+            <MedlineCitation><PMID>20000002</PMID></MedlineCitation>
+            </PubmedArticle></PubmedArticleSet>`))
+        if err == nil || !strings.Contains(err.Error(), "pubplace PMID instead of PubMed") {
+            t.Errorf("expected pubplace conflict got %v", err)
+        }
+    })
+}
@@ -0,0 +1,188 @@
+package gochado
+
+import (
+    "bytes"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// Kinds of references recognized by ReferenceNormalizer, the generic Db:Id
+// ones are of the kind of their db
+const (
+    RefPubmed    = "PMID"
+    RefPmcid     = "PMCID"
+    RefDoi       = "DOI"
+    RefGoRef     = "GO_REF"
+    RefDictybase = "dictyBase"
+)
+
+var (
+    digitsRgxp   = regexp.MustCompile(`^\d+$`)
+    pmcidRgxp    = regexp.MustCompile(`^(?i)(?:PMC)?(\d+)$`)
+    doiRgxp      = regexp.MustCompile(`^10\.\d+/\S+$`)
+    doiUrlRgxp   = regexp.MustCompile(`^(?i)https?://(?:dx\.)?doi\.org/(.+)$`)
+    dictyPubRgxp = regexp.MustCompile(`^(?i)PUB(\d+)$`)
+)
+
+// A publication reference with its canonical identifier and the pubplace it
+// is stored with in the pub table
+type Reference struct {
+    Kind     string
+    Id       string
+    Pubplace string
+}
+
+func (r *Reference) String() string {
+    return r.Kind + ":" + r.Id
+}
+
+// Error for a reference that could not be parsed
+type ReferenceError struct {
+    Ref    string
+    Reason string
+}
+
+func (e *ReferenceError) Error() string {
+    return fmt.Sprintf("reference %q: %s", e.Ref, e.Reason)
+}
+
+// Errors of all the references that could not be parsed
+type ReferenceErrors []*ReferenceError
+
+func (e ReferenceErrors) Error() string {
+    var b bytes.Buffer
+    for i, re := range e {
+        if i > 0 {
+            b.WriteString("\n")
+        }
+        b.WriteString(re.Error())
+    }
+    return b.String()
+}
+
+// Canonicalizes publication references of annotation files. Recognizes
+//   PMID:123, PubMed:123
+//   PMCID:PMC123, PMCID:123, PMC123
+//   DOI:10.1038/nature03481, doi:..., https://doi.org/..., 10.1038/...
+//   GO_REF:0000033, GO_REF:33
+//   dictyBase:PUB123, PUB123
+// and any other reference of Db:Id form. The DOIs are lowercased and the
+// GO_REF ones are padded to seven digits. The pubplace is looked up by the
+// kind of reference, it is the kind itself in absence of a mapping.
+/*
+   n := NewReferenceNormalizer()
+   n.SetPubplace(RefGoRef, "GOC")
+   r, err := n.Normalize("doi:10.1038/NATURE03481")
+   // r.Id == "10.1038/nature03481", r.Pubplace == "DOI"
+*/
+type ReferenceNormalizer struct {
+    pubplaces map[string]string
+}
+
+// Gets a new instance with the default pubplaces, PubMed for PMID, the db
+// names of PubmedLoader for PMCID and DOI and the kind itself for the rest
+func NewReferenceNormalizer() *ReferenceNormalizer {
+    return &ReferenceNormalizer{pubplaces: map[string]string{
+        RefPubmed:    PubmedPubplace,
+        RefPmcid:     PmcidDb,
+        RefDoi:       DoiDb,
+        RefGoRef:     RefGoRef,
+        RefDictybase: RefDictybase,
+    }}
+}
+
+// Sets the pubplace of a kind of reference, the kind could also be the db of
+// a generic Db:Id reference
+func (n *ReferenceNormalizer) SetPubplace(kind, pubplace string) {
+    n.pubplaces[kind] = pubplace
+}
+
+func (n *ReferenceNormalizer) Pubplace(kind string) string {
+    if p, ok := n.pubplaces[kind]; ok {
+        return p
+    }
+    return kind
+}
+
+func (n *ReferenceNormalizer) reference(kind, id string) *Reference {
+    return &Reference{Kind: kind, Id: id, Pubplace: n.Pubplace(kind)}
+}
+
+// Parses a single reference, the error is of *ReferenceError type
+func (n *ReferenceNormalizer) Normalize(ref string) (*Reference, error) {
+    r := strings.TrimSpace(ref)
+    if len(r) == 0 {
+        return nil, &ReferenceError{ref, "empty reference"}
+    }
+    if m := doiUrlRgxp.FindStringSubmatch(r); m != nil {
+        return n.doi(ref, m[1])
+    }
+    if !strings.Contains(r, ":") {
+        switch {
+        case pmcidRgxp.MatchString(r) && !digitsRgxp.MatchString(r):
+            return n.reference(RefPmcid, "PMC"+pmcidRgxp.FindStringSubmatch(r)[1]), nil
+        case dictyPubRgxp.MatchString(r):
+            return n.reference(RefDictybase, "PUB"+dictyPubRgxp.FindStringSubmatch(r)[1]), nil
+        case doiRgxp.MatchString(r):
+            return n.doi(ref, r)
+        }
+        return nil, &ReferenceError{ref, "unrecognized reference without db prefix"}
+    }
+    d := strings.SplitN(r, ":", 2)
+    prefix, id := strings.TrimSpace(d[0]), strings.TrimSpace(d[1])
+    if len(prefix) == 0 || len(id) == 0 {
+        return nil, &ReferenceError{ref, "expected Db:Id form"}
+    }
+    switch strings.ToUpper(prefix) {
+    case "PMID", "PUBMED":
+        if !digitsRgxp.MatchString(id) {
+            return nil, &ReferenceError{ref, "PMID is not numeric"}
+        }
+        return n.reference(RefPubmed, id), nil
+    case "PMCID", "PMC":
+        m := pmcidRgxp.FindStringSubmatch(id)
+        if m == nil {
+            return nil, &ReferenceError{ref, "PMCID is not of PMC followed by digits"}
+        }
+        return n.reference(RefPmcid, "PMC"+m[1]), nil
+    case "DOI":
+        return n.doi(ref, id)
+    case "GO_REF":
+        if !digitsRgxp.MatchString(id) || len(id) > 7 {
+            return nil, &ReferenceError{ref, "GO_REF is not of seven digits"}
+        }
+        return n.reference(RefGoRef, strings.Repeat("0", 7-len(id))+id), nil
+    case "DICTYBASE":
+        if m := dictyPubRgxp.FindStringSubmatch(id); m != nil {
+            return n.reference(RefDictybase, "PUB"+m[1]), nil
+        }
+    }
+    return n.reference(prefix, id), nil
+}
+
+func (n *ReferenceNormalizer) doi(ref, id string) (*Reference, error) {
+    if !doiRgxp.MatchString(id) {
+        return nil, &ReferenceError{ref, "DOI is not of 10.prefix/suffix form"}
+    }
+    return n.reference(RefDoi, strings.ToLower(id)), nil
+}
+
+// Parses a list of references, returns the parsed ones in the same order
+// along with ReferenceErrors of the rest, nil if all of them are parsed
+func (n *ReferenceNormalizer) NormalizeAll(refs []string) ([]*Reference, error) {
+    parsed := make([]*Reference, 0)
+    var errs ReferenceErrors
+    for _, ref := range refs {
+        r, err := n.Normalize(ref)
+        if err != nil {
+            errs = append(errs, err.(*ReferenceError))
+            continue
+        }
+        parsed = append(parsed, r)
+    }
+    if len(errs) > 0 {
+        return parsed, errs
+    }
+    return parsed, nil
+}
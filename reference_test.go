@@ -0,0 +1,56 @@
+package gochado
+
+import (
+    "testing"
+)
+
+func TestReferenceNormalizer(t *testing.T) {
+    n := NewReferenceNormalizer()
+    for _, c := range []struct {
+        ref, kind, id, pubplace string
+    }{
+        {"PMID:15875012", RefPubmed, "15875012", "PubMed"},
+        {"pubmed:15875012", RefPubmed, "15875012", "PubMed"},
+        {"PMCID:PMC1352341", RefPmcid, "PMC1352341", "PMCID"},
+        {"PMCID:1352341", RefPmcid, "PMC1352341", "PMCID"},
+        {"pmc1352341", RefPmcid, "PMC1352341", "PMCID"},
+        {"DOI:10.1038/nature03481", RefDoi, "10.1038/nature03481", "DOI"},
+        {"doi:10.1038/NATURE03481", RefDoi, "10.1038/nature03481", "DOI"},
+        {"https://doi.org/10.1093/nar/gks1064", RefDoi, "10.1093/nar/gks1064", "DOI"},
+        {"http://dx.doi.org/10.1093/nar/gks1064", RefDoi, "10.1093/nar/gks1064", "DOI"},
+        {"10.1093/nar/gks1064", RefDoi, "10.1093/nar/gks1064", "DOI"},
+        {"GO_REF:0000033", RefGoRef, "0000033", "GO_REF"},
+        {"GO_REF:33", RefGoRef, "0000033", "GO_REF"},
+        {"dictyBase:PUB0000123", RefDictybase, "PUB0000123", "dictyBase"},
+        {"PUB0000123", RefDictybase, "PUB0000123", "dictyBase"},
+        {"dictyBase_REF:10157", "dictyBase_REF", "10157", "dictyBase_REF"},
+        {" Reactome:R-DDI-1 ", "Reactome", "R-DDI-1", "Reactome"},
+    } {
+        r, err := n.Normalize(c.ref)
+        if err != nil {
+            t.Errorf("unexpected error %s", err)
+            continue
+        }
+        if r.Kind != c.kind || r.Id != c.id || r.Pubplace != c.pubplace {
+            t.Errorf("expected %s %s %s for %s got %+v", c.kind, c.id, c.pubplace, c.ref, r)
+        }
+    }
+    for _, ref := range []string{"", "15875012", "nonsense", "PMID:abc", "PMID:", ":123", "DOI:11.1/x", "GO_REF:00000333", "PMCID:PMX1"} {
+        if _, err := n.Normalize(ref); err == nil {
+            t.Errorf("expected error for %q", ref)
+        } else if _, ok := err.(*ReferenceError); !ok {
+            t.Errorf("expected *ReferenceError got %T", err)
+        }
+    }
+
+    n.SetPubplace(RefPubmed, "NCBI")
+    n.SetPubplace("Reactome", "REACT")
+    refs, err := n.NormalizeAll([]string{"PMID:1", "bogus", "Reactome:R-1", "PMID:x"})
+    if len(refs) != 2 || refs[0].Pubplace != "NCBI" || refs[1].Pubplace != "REACT" {
+        t.Errorf("unexpected references %v", refs)
+    }
+    errs, ok := err.(ReferenceErrors)
+    if !ok || len(errs) != 2 || errs[0].Ref != "bogus" {
+        t.Errorf("expected 2 reference errors got %v", err)
+    }
+}
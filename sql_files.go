@@ -0,0 +1,92 @@
+package gochado
+
+import (
+    "embed"
+    "fmt"
+    "io/ioutil"
+    "path"
+    "strings"
+    "sync"
+)
+
+// Default ini files with sql statements of the loaders, they are compiled into
+// the binary. Each file is named after its loader, for example gpad.ini
+//go:embed data/*.ini
+var sqlFiles embed.FS
+
+// Registry of external ini files keyed by loader names, they take precedence
+// over the embedded ones
+var sqlOverrides = struct {
+    files map[string]string
+    sync.RWMutex
+}{files: make(map[string]string)}
+
+// Returns a parser with the sql statements of a loader for a particular
+// dialect. The content is read from the external file if one is set for the
+// loader, otherwise from the embedded ini file.
+/*
+    parser, err := SQLFor("gpad", "sqlite")
+    if err != nil {
+        log.Fatal(err)
+    }
+    staging := staging.NewStagingSqlite(dbh, parser)
+*/
+func SQLFor(loader, dialect string) (*SqlParser, error) {
+    content, err := SQLContent(loader)
+    if err != nil {
+        return nil, err
+    }
+    parser, err := NewSqlParserFromString(content)
+    if err != nil {
+        return nil, fmt.Errorf("error %s in sql of loader %s", err, loader)
+    }
+    parser.SetDialect(dialect)
+    return parser, nil
+}
+
+// Returns the ini content of a loader
+func SQLContent(loader string) (string, error) {
+    sqlOverrides.RLock()
+    file, ok := sqlOverrides.files[loader]
+    sqlOverrides.RUnlock()
+    if ok {
+        c, err := ioutil.ReadFile(file)
+        if err != nil {
+            return "", fmt.Errorf("error %s in reading sql file %s of loader %s", err, file, loader)
+        }
+        return string(c), nil
+    }
+    c, err := sqlFiles.ReadFile(path.Join("data", loader+".ini"))
+    if err != nil {
+        return "", fmt.Errorf("no sql file for loader %s", loader)
+    }
+    return string(c), nil
+}
+
+// Set an external ini file for a loader that will be used instead of the
+// embedded one
+func SetSQLFile(loader, file string) {
+    sqlOverrides.Lock()
+    defer sqlOverrides.Unlock()
+    sqlOverrides.files[loader] = file
+}
+
+// Removes the external ini file of a loader, the embedded one will be used
+// again
+func ResetSQLFile(loader string) {
+    sqlOverrides.Lock()
+    defer sqlOverrides.Unlock()
+    delete(sqlOverrides.files, loader)
+}
+
+// List of loaders with embedded ini files
+func SQLLoaders() []string {
+    var l []string
+    entries, _ := sqlFiles.ReadDir("data")
+    for _, e := range entries {
+        if strings.HasSuffix(e.Name(), ".ini") {
+            l = append(l, strings.TrimSuffix(e.Name(), ".ini"))
+        }
+    }
+    return l
+}
@@ -0,0 +1,58 @@
+package gochado
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestSQLFor(t *testing.T) {
+    parser, err := SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Fatalf("should have found sql for gpad error: %s", err)
+    }
+    if parser.Dialect() != "sqlite" {
+        t.Errorf("expected dialect %s got %s", "sqlite", parser.Dialect())
+    }
+    if len(parser.GetSection("insert_feature_cvterm")) == 0 {
+        t.Error("should have section insert_feature_cvterm")
+    }
+    if _, err := SQLFor("gaf", "sqlite"); err == nil {
+        t.Error("should not have found sql for gaf")
+    }
+    found := false
+    for _, l := range SQLLoaders() {
+        if l == "gpad" {
+            found = true
+        }
+    }
+    if !found {
+        t.Error("gpad should be listed among the loaders")
+    }
+
+    dir, err := ioutil.TempDir("", "gochado")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    file := filepath.Join(dir, "gpad.ini")
+    err = ioutil.WriteFile(file, []byte("[insert_feature_cvterm]\nSELECT 1\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    SetSQLFile("gpad", file)
+    parser, err = SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Fatalf("should have read the external file error: %s", err)
+    }
+    if v := parser.GetSection("insert_feature_cvterm"); !strings.Contains(v, "SELECT 1") {
+        t.Errorf("expected section from external file got %s", v)
+    }
+    ResetSQLFile("gpad")
+    parser, _ = SQLFor("gpad", "sqlite")
+    if v := parser.GetSection("insert_feature_cvterm"); strings.Contains(v, "SELECT 1") {
+        t.Error("expected section from embedded file")
+    }
+}
@@ -0,0 +1,527 @@
+package gochado
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "github.com/jmoiron/sqlx"
+    "io"
+    "os"
+    "regexp"
+    "strings"
+    "text/template"
+)
+
+// Parsing sql statements from ini style config file. Each ini section expects
+// to have a sql statement
+/*
+In caboose.ini file
+
+[create_bag]
+CREATE TABLE bag (
+    id INTEGER PRIMARY KEY NOT NULL,
+    name TEXT
+);
+
+[select_bag]
+SELECT id FROM bag WHERE name = ?
+
+[insert_bag]
+INSERT INTO bag(name) VALUES(?)
+
+.......
+
+
+   parser, err := NewSqlParserFromFile("caboose.ini")
+   if err != nil {
+       log.Fatal(err)
+   }
+   for _, section := range parser.Sections() {
+       fmt.Printf("section:%s\nvalue:%s\n\n",section,parser.GetSection(section))
+   }
+
+Statements specific to a database backend are kept in sections suffixed
+with the dialect name, they take precedence over the generic section once the
+dialect is set. The placeholders are expected in the ? form, they are
+rebound to the form suitable for the dialect.
+
+[select_bag:postgres]
+SELECT id FROM bag WHERE name ILIKE ?
+
+   parser.SetDialect("postgres")
+   parser.GetSection("select_bag") // SELECT id FROM bag WHERE name ILIKE $1
+
+Sections could also be rendered as text/template once a parameter is set for
+the parser. Sections with *define_* prefix hold shared snippets, they are
+available to every other section as named templates.
+
+[define_bag_color]
+color = {{quote .Color}}
+
+[select_colored_bag]
+SELECT id FROM bag WHERE {{template "define_bag_color" .}}
+
+   err := parser.SetParams(struct{ Color string }{"red"})
+   parser.GetSection("select_colored_bag") // SELECT id FROM bag WHERE color = 'red'
+
+*/
+type SqlParser struct {
+    // section names in the order they appear in the ini content
+    keys    []string
+    content map[string]string
+    // name of the database backend
+    dialect string
+    // parameter for rendering the sections as template
+    params interface{}
+    // templates from the define sections
+    templates *template.Template
+    // cache of rendered sections
+    rendered map[string]string
+    logger   Logger
+}
+
+// Separator between section name and its dialect
+const dialectSep = ":"
+
+// Prefix of sections with shared template snippets
+const definePrefix = "define_"
+
+// Functions available to the templates
+var templateFuncs = template.FuncMap{
+    // quotes a string as sql literal
+    "quote": quoteLiteral,
+    // quotes a list of strings and joins them with comma, suitable for IN
+    // clause
+    "quotelist": func(values []string) string {
+        q := make([]string, len(values))
+        for i, v := range values {
+            q[i] = quoteLiteral(v)
+        }
+        return strings.Join(q, ", ")
+    },
+}
+
+func quoteLiteral(v string) string {
+    return "'" + strings.Replace(v, "'", "''", -1) + "'"
+}
+
+// Parameters for rendering sql sections of ontology association loaders such
+// as GPAD
+type SqlParams struct {
+    // Cv of association properties such as qualifier, date, source and with
+    AssociationCv string
+    // Db and cvs of ontology terms
+    OntologyDb  string
+    OntologyCvs []string
+    // Db and cv of evidence codes
+    EvidenceDb string
+    EvidenceCv string
+    // Identifier of a persistent staging run, the staging tables are
+    // temporary unless it is set
+    RunId string
+}
+
+var runIdRgxp = regexp.MustCompile(`^\w+$`)
+
+// Opening tag of a dollar quoted string of postgres, such as $$ or $body$
+var dollarQuoteRgxp = regexp.MustCompile(`^\$(?:[A-Za-z_]\w*)?\$`)
+
+// Name of a staging table, the temporary one is prefixed with temp_ and the
+// persistent one with the run identifier
+func (p SqlParams) StagingTable(name string) (string, error) {
+    if len(p.RunId) == 0 {
+        return "temp_" + name, nil
+    }
+    if !runIdRgxp.MatchString(p.RunId) {
+        return "", fmt.Errorf("run id %q should only have letters, digits or underscore", p.RunId)
+    }
+    return "staging_" + p.RunId + "_" + name, nil
+}
+
+// Parameters for loading gene ontology associations
+func DefaultGpadParams() SqlParams {
+    return SqlParams{
+        AssociationCv: "gene_ontology_association",
+        OntologyDb:    "GO",
+        OntologyCvs:   []string{"biological_process", "molecular_function", "cellular_component"},
+        EvidenceDb:    "ECO",
+        EvidenceCv:    "eco",
+    }
+}
+
+// Error in parsing ini content
+type ParseError struct {
+    // line number, starts from 1
+    Line int
+    Msg  string
+}
+
+func (e *ParseError) Error() string {
+    return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse ini sql content from a string and returns a new instance
+func NewSqlParserFromString(content string) (*SqlParser, error) {
+    return NewSqlParser(strings.NewReader(content))
+}
+
+// Parse ini sql content from a file and returns a new instance
+func NewSqlParserFromFile(file string) (*SqlParser, error) {
+    r, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer r.Close()
+    parser, err := NewSqlParser(r)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %s", file, err)
+    }
+    return parser, nil
+}
+
+// Parse ini sql content from a reader and returns a new instance
+func NewSqlParser(r io.Reader) (*SqlParser, error) {
+    keys, content, err := ParseConfig(r)
+    if err != nil {
+        return nil, err
+    }
+    return &SqlParser{keys: keys, content: content}, nil
+}
+
+// Parse ini content, returns the section names in their order and the
+// content keyed by section names. Lines starting with # or ; are comments
+// and blank lines are skipped. Both LF and CRLF line endings are accepted.
+// Text outside of any section, malformed section headers and duplicate
+// sections are reported as *ParseError.
+func ParseConfig(r io.Reader) ([]string, map[string]string, error) {
+    var curr string
+    var b bytes.Buffer
+    keys := make([]string, 0)
+    content := make(map[string]string)
+    lines := make(map[string]int)
+
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+    var num int
+    for scanner.Scan() {
+        num++
+        line := strings.TrimRight(scanner.Text(), "\r")
+        if len(strings.TrimSpace(line)) == 0 {
+            continue
+        }
+        // skip comment
+        if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+            continue
+        }
+        if strings.HasPrefix(line, "[") {
+            header := strings.TrimRight(line, " \t")
+            if !strings.HasSuffix(header, "]") {
+                return nil, nil, &ParseError{num, fmt.Sprintf("malformed section header %q", line)}
+            }
+            key := strings.TrimSpace(header[1 : len(header)-1])
+            if len(key) == 0 {
+                return nil, nil, &ParseError{num, "empty section name"}
+            }
+            if l, ok := lines[key]; ok {
+                return nil, nil, &ParseError{num, fmt.Sprintf("duplicate section %s, first defined at line %d", key, l)}
+            }
+            if len(curr) != 0 {
+                content[curr] = b.String()
+                b.Reset()
+            }
+            curr = key
+            lines[key] = num
+            keys = append(keys, key)
+            continue
+        }
+        if len(curr) == 0 {
+            return nil, nil, &ParseError{num, "text outside of any section"}
+        }
+        b.WriteString(line)
+        b.WriteString("\n")
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, nil, &ParseError{num + 1, err.Error()}
+    }
+    if len(curr) != 0 {
+        content[curr] = b.String()
+    }
+    return keys, content, nil
+}
+
+// Set the parameter for rendering sections as template. The *define_*
+// sections are parsed here, so any error in them is returned.
+func (ini *SqlParser) SetParams(params interface{}) error {
+    t := template.New(definePrefix).Funcs(templateFuncs)
+    for _, k := range ini.keys {
+        if !strings.HasPrefix(k, definePrefix) {
+            continue
+        }
+        if _, err := t.New(k).Parse(strings.TrimSpace(ini.content[k])); err != nil {
+            return fmt.Errorf("error %s in parsing section %s", err, k)
+        }
+    }
+    ini.params = params
+    ini.templates = t
+    ini.rendered = make(map[string]string)
+    return nil
+}
+
+// Parameter for rendering sections as template
+func (ini *SqlParser) Params() interface{} {
+    return ini.params
+}
+
+// Returns the dialect name of a database or transaction handle, which is
+// either sqlite or postgres for the supported backends, otherwise the driver
+// name.
+func DialectFor(h Handle) string {
+    switch h.DriverName() {
+    case "sqlite3", "sqlite":
+        return "sqlite"
+    case "postgres", "pgx", "pq":
+        return "postgres"
+    }
+    return h.DriverName()
+}
+
+// Set the dialect for looking up sections
+func (ini *SqlParser) SetDialect(dialect string) {
+    ini.dialect = dialect
+    if ini.rendered != nil {
+        ini.rendered = make(map[string]string)
+    }
+}
+
+// Dialect for looking up sections
+func (ini *SqlParser) Dialect() string {
+    return ini.dialect
+}
+
+// List of ini section in the order they are defined. The dialect specific
+// sections are listed by their generic name and only for the current
+// dialect. The *define_* sections are not listed.
+func (ini *SqlParser) Sections() []string {
+    var s []string
+    seen := make(map[string]bool)
+    for _, k := range ini.keys {
+        if strings.HasPrefix(k, definePrefix) {
+            continue
+        }
+        if strings.Contains(k, dialectSep) {
+            d := strings.SplitN(k, dialectSep, 2)
+            if d[1] != ini.dialect {
+                continue
+            }
+            k = d[0]
+        }
+        if !seen[k] {
+            seen[k] = true
+            s = append(s, k)
+        }
+    }
+    return s
+}
+
+// Checks for the presence of sections, either generic or specific to the
+// current dialect. Returns an error listing all of the missing ones.
+func (ini *SqlParser) Require(sections ...string) error {
+    var missing []string
+    for _, s := range sections {
+        if !ini.HasSection(s) {
+            missing = append(missing, s)
+        }
+    }
+    if len(missing) != 0 {
+        return fmt.Errorf("missing required sections %s", strings.Join(missing, ", "))
+    }
+    return nil
+}
+
+// Checks for the presence of a section, either generic or specific to the
+// current dialect
+func (ini *SqlParser) HasSection(key string) bool {
+    if _, ok := ini.content[key]; ok {
+        return true
+    }
+    if len(ini.dialect) != 0 {
+        if _, ok := ini.content[key+dialectSep+ini.dialect]; ok {
+            return true
+        }
+    }
+    return false
+}
+
+// Sets the logger, a nil value switches back to the default one
+func (ini *SqlParser) SetLogger(l Logger) {
+    ini.logger = l
+}
+
+func (ini *SqlParser) Logger() Logger {
+    if ini.logger == nil {
+        return DefaultLogger()
+    }
+    return ini.logger
+}
+
+// Value of a particular section. The dialect specific section is returned if
+// present, otherwise the generic one. The section is rendered as template if
+// the parameter is set and the placeholders are rebound for the dialect.
+// Quits the program if the template could not be rendered.
+func (ini *SqlParser) GetSection(key string) string {
+    v, err := ini.RenderSection(key)
+    if err != nil {
+        Fatal(ini.Logger(), "could not render section", "section", key, "error", err)
+    }
+    return v
+}
+
+// Same as GetSection, however returns the error in rendering the template.
+func (ini *SqlParser) RenderSection(key string) (string, error) {
+    if len(ini.dialect) != 0 {
+        if _, ok := ini.content[key+dialectSep+ini.dialect]; ok {
+            key = key + dialectSep + ini.dialect
+        }
+    }
+    v, ok := ini.content[key]
+    if !ok {
+        return "", nil
+    }
+    if ini.params == nil {
+        return ini.rebind(v), nil
+    }
+    if r, ok := ini.rendered[key]; ok {
+        return r, nil
+    }
+    t, err := ini.templates.Clone()
+    if err != nil {
+        return "", err
+    }
+    if _, err := t.New(key).Parse(v); err != nil {
+        return "", fmt.Errorf("error %s in parsing section %s", err, key)
+    }
+    var b bytes.Buffer
+    if err := t.ExecuteTemplate(&b, key, ini.params); err != nil {
+        return "", fmt.Errorf("error %s in rendering section %s", err, key)
+    }
+    r := ini.rebind(b.String())
+    ini.rendered[key] = r
+    return r, nil
+}
+
+func (ini *SqlParser) rebind(query string) string {
+    if len(ini.dialect) == 0 {
+        return query
+    }
+    return sqlx.Rebind(sqlx.BindType(ini.dialect), query)
+}
+
+// Sql error of a section
+type SectionError struct {
+    Section string
+    Err     error
+}
+
+func (e *SectionError) Error() string {
+    return fmt.Sprintf("section %s: %s", e.Section, e.Err)
+}
+
+// List of sql errors of sections found during validation
+type ValidationError []*SectionError
+
+func (e ValidationError) Error() string {
+    msg := make([]string, len(e))
+    for i, se := range e {
+        msg[i] = se.Error()
+    }
+    return fmt.Sprintf("%d invalid sections\n%s", len(e), strings.Join(msg, "\n"))
+}
+
+// Validates sections by preparing, without executing, their sql statements
+// against the database or within a transaction. All the sections are
+// validated if none is given. A section with more than one statement is
+// split and each of the statements is prepared on its own, so they could not
+// refer to the tables created by the earlier ones of the same section. The
+// tables referred by the statements are expected to exist, so the sections
+// that create tables have to be validated before the tables are created.
+// Returns a ValidationError with every invalid section.
+func (ini *SqlParser) Validate(h Handle, sections ...string) error {
+    if len(sections) == 0 {
+        sections = ini.Sections()
+    }
+    var verr ValidationError
+    for _, s := range sections {
+        if !ini.HasSection(s) {
+            verr = append(verr, &SectionError{s, fmt.Errorf("section is absent")})
+            continue
+        }
+        q, err := ini.RenderSection(s)
+        if err != nil {
+            verr = append(verr, &SectionError{s, err})
+            continue
+        }
+        for i, sq := range splitStatements(q) {
+            stmt, err := h.Preparex(sq)
+            if err != nil {
+                verr = append(verr, &SectionError{s, fmt.Errorf("statement %d: %s", i+1, err)})
+                break
+            }
+            _ = stmt.Close()
+        }
+    }
+    if len(verr) != 0 {
+        return verr
+    }
+    return nil
+}
+
+// Splits sql text into its statements on the semicolons that are outside of
+// quoted strings, identifiers, comments and dollar quoted bodies of postgres.
+// The empty statements are left out.
+func splitStatements(q string) []string {
+    stmts := make([]string, 0)
+    start := 0
+    add := func(end int) {
+        if st := strings.TrimSpace(q[start:end]); len(st) != 0 {
+            stmts = append(stmts, st)
+        }
+    }
+    for i := 0; i < len(q); i++ {
+        switch c := q[i]; {
+        case c == '\'' || c == '"':
+            // a doubled quote escapes itself, so it is skipped as a
+            // closing and a reopening one
+            for i++; i < len(q) && q[i] != c; i++ {
+            }
+        case c == '-' && strings.HasPrefix(q[i:], "--"):
+            if end := strings.IndexByte(q[i:], '\n'); end >= 0 {
+                i += end
+            } else {
+                i = len(q)
+            }
+        case c == '/' && strings.HasPrefix(q[i:], "/*"):
+            if end := strings.Index(q[i+2:], "*/"); end >= 0 {
+                i += end + 3
+            } else {
+                i = len(q)
+            }
+        case c == '$':
+            m := dollarQuoteRgxp.FindString(q[i:])
+            if len(m) == 0 {
+                continue
+            }
+            if end := strings.Index(q[i+len(m):], m); end >= 0 {
+                i += len(m) + end + len(m) - 1
+            } else {
+                i = len(q)
+            }
+        case c == ';':
+            add(i)
+            start = i + 1
+        }
+    }
+    if start < len(q) {
+        add(len(q))
+    }
+    return stmts
+}
@@ -0,0 +1,189 @@
+package gochado
+
+import (
+    "github.com/dictybase/testchado"
+    "strings"
+    "testing"
+)
+
+func TestSqlParser(t *testing.T) {
+    // CRLF line ending, trailing spaces in header and no newline at the end
+    content := "# bag statements\r\n[create_bag]  \r\nCREATE TABLE bag (\r\n    id INTEGER\r\n)\r\n\r\n" +
+        "[select_bag]\r\nSELECT id FROM bag\r\n\r\n[insert_bag]\nINSERT INTO bag(id) VALUES(?)"
+    parser, err := NewSqlParserFromString(content)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sections := parser.Sections()
+    expected := []string{"create_bag", "select_bag", "insert_bag"}
+    if strings.Join(sections, ",") != strings.Join(expected, ",") {
+        t.Errorf("expected sections %v got %v", expected, sections)
+    }
+    if v := parser.GetSection("create_bag"); v != "CREATE TABLE bag (\n    id INTEGER\n)\n" {
+        t.Errorf("unexpected content of create_bag %q", v)
+    }
+    if v := parser.GetSection("insert_bag"); !strings.Contains(v, "VALUES(?)") {
+        t.Errorf("should have the last section without newline got %q", v)
+    }
+    if err := parser.Require("create_bag", "insert_bag"); err != nil {
+        t.Error(err)
+    }
+    err = parser.Require("create_bag", "drop_bag", "update_bag")
+    if err == nil || !strings.Contains(err.Error(), "drop_bag, update_bag") {
+        t.Errorf("expected error with missing sections got %v", err)
+    }
+
+    for c, line := range map[string]int{
+        "SELECT 1\n[select_bag]\nSELECT 2\n":                 1,
+        "[select_bag]\nSELECT 1\n\n[select_bag]\nSELECT 2\n": 4,
+        "# comment\n[select_bag\nSELECT 1\n":                 2,
+        "[select_bag]\nSELECT 1\n[ ]\nSELECT 2\n":            3,
+    } {
+        _, err := NewSqlParserFromString(c)
+        perr, ok := err.(*ParseError)
+        if !ok {
+            t.Errorf("expected parse error for %q got %v", c, err)
+            continue
+        }
+        if perr.Line != line {
+            t.Errorf("expected error at line %d got %d for %q", line, perr.Line, c)
+        }
+    }
+}
+
+func TestSqlParserDialect(t *testing.T) {
+    content := `
+[select_bag]
+SELECT id FROM bag WHERE name = ? AND color = ?
+
+[select_bag:postgres]
+SELECT id FROM bag WHERE name ILIKE ? AND color = ?
+
+[insert_bag]
+INSERT INTO bag(name) VALUES(?)
+
+[vacuum_bag:sqlite]
+VACUUM
+`
+    parser, err := NewSqlParserFromString(content)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(parser.Sections()) != 2 {
+        t.Errorf("expected %d generic sections got %d", 2, len(parser.Sections()))
+    }
+    if v := parser.GetSection("select_bag"); !strings.Contains(v, "name = ? AND color = ?") {
+        t.Errorf("expected generic section got %s", v)
+    }
+
+    parser.SetDialect("postgres")
+    if v := parser.GetSection("select_bag"); !strings.Contains(v, "name ILIKE $1 AND color = $2") {
+        t.Errorf("expected postgres section with rebound placeholders got %s", v)
+    }
+    if v := parser.GetSection("insert_bag"); !strings.Contains(v, "VALUES($1)") {
+        t.Errorf("expected generic section with rebound placeholders got %s", v)
+    }
+    if v := parser.GetSection("vacuum_bag"); len(v) != 0 {
+        t.Errorf("expected no section for postgres got %s", v)
+    }
+
+    parser.SetDialect("sqlite")
+    if v := parser.GetSection("select_bag"); !strings.Contains(v, "name = ? AND color = ?") {
+        t.Errorf("expected generic section got %s", v)
+    }
+    if len(parser.Sections()) != 3 {
+        t.Errorf("expected %d sections for sqlite got %d", 3, len(parser.Sections()))
+    }
+}
+
+func TestSqlParserTemplate(t *testing.T) {
+    content := `
+[define_bag_color]
+color IN ({{quotelist .Colors}})
+
+[select_bag]
+SELECT id FROM bag WHERE name = {{quote .Name}} AND {{template "define_bag_color" .}}
+
+[select_any_bag]
+SELECT id FROM bag WHERE name = ?
+`
+    parser, err := NewSqlParserFromString(content)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, s := range parser.Sections() {
+        if strings.HasPrefix(s, "define_") {
+            t.Errorf("define section %s should not be listed", s)
+        }
+    }
+    if v := parser.GetSection("select_bag"); !strings.Contains(v, "{{quote .Name}}") {
+        t.Errorf("expected unrendered section got %s", v)
+    }
+    params := struct {
+        Name   string
+        Colors []string
+    }{"cat's", []string{"red", "blue"}}
+    if err = parser.SetParams(params); err != nil {
+        t.Fatal(err)
+    }
+    v := parser.GetSection("select_bag")
+    if !strings.Contains(v, "name = 'cat''s' AND color IN ('red', 'blue')") {
+        t.Errorf("expected rendered section got %s", v)
+    }
+    parser.SetDialect("postgres")
+    if v := parser.GetSection("select_any_bag"); !strings.Contains(v, "name = $1") {
+        t.Errorf("expected rendered section with rebound placeholder got %s", v)
+    }
+    noparams, _ := NewSqlParserFromString("[select_bag]\nSELECT {{.Missing}}")
+    if _, err := noparams.RenderSection("select_bag"); err != nil {
+        t.Errorf("should not render without parameter error: %s", err)
+    }
+    bad, _ := NewSqlParserFromString("[define_bad]\n{{if}}\n")
+    if err := bad.SetParams(params); err == nil {
+        t.Error("expected error in parsing define section")
+    }
+}
+
+func TestSplitStatements(t *testing.T) {
+    q := `INSERT INTO bag(name) VALUES('a;b');
+    -- comment; with semicolon
+    SELECT "odd;name" FROM bag /* block; comment */;
+    CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;
+    SELECT $1 ;  `
+    stmts := splitStatements(q)
+    if len(stmts) != 4 {
+        t.Fatalf("expected %d statements got %d %q", 4, len(stmts), stmts)
+    }
+    if stmts[0] != "INSERT INTO bag(name) VALUES('a;b')" {
+        t.Errorf("unexpected first statement %q", stmts[0])
+    }
+    if !strings.HasSuffix(stmts[2], "$body$ LANGUAGE sql") {
+        t.Errorf("unexpected dollar quoted statement %q", stmts[2])
+    }
+    if stmts[3] != "SELECT $1" {
+        t.Errorf("unexpected last statement %q", stmts[3])
+    }
+}
+
+func TestSqlParserValidate(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    chado.DeploySchema()
+    defer chado.DropSchema()
+    parser, err := NewSqlParserFromString("[select_two]\nSELECT 1 FROM db;\nSELECT 1 FROM absent_table\n\n[select_one]\nSELECT 1 FROM cv\n")
+    if err != nil {
+        t.Fatal(err)
+    }
+    tx, err := chado.DBHandle().Beginx()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer tx.Rollback()
+    err = parser.Validate(tx)
+    verr, ok := err.(ValidationError)
+    if !ok {
+        t.Fatalf("expected validation error got %v", err)
+    }
+    if len(verr) != 1 || verr[0].Section != "select_two" {
+        t.Errorf("expected the later statement of select_two to be invalid got %s", verr)
+    }
+}
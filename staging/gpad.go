@@ -1,37 +1,90 @@
 package staging
 
 import (
-    "bytes"
+    "context"
+    "database/sql/driver"
+    "errors"
     "fmt"
     "github.com/dictybase/gochado"
     "github.com/jmoiron/sqlx"
-    "log"
+    "reflect"
     "regexp"
-    "strconv"
     "strings"
+    "time"
 )
 
 var br = regexp.MustCompile(`^\s+$`)
 
+// Default number of rows that are inserted by a single statement during bulk
+// load of staging tables
+const DefaultBatchSize = 500
+
+// Maximum number of bound parameters in a single statement. It is the
+// default SQLITE_MAX_VARIABLE_NUMBER, the lowest limit among the supported
+// backends.
+const maxBindParams = 999
+
+// Minimum number of tab separated columns of a GPAD row
+const gpadColumns = 10
+
 // Publication record with id and namespace
+//
+// Deprecated: use gochado.Reference of gochado.ReferenceNormalizer
 type PubRecord struct {
     id       string
     pubplace string
 }
 
+// Deprecated: use NormalizeAll of gochado.ReferenceNormalizer, the references
+// that could not be parsed are left out here
 func NormaLizePubRecord(pubs []string) []*PubRecord {
+    refs, _ := gochado.NewReferenceNormalizer().NormalizeAll(pubs)
     pr := make([]*PubRecord, 0)
-    for _, r := range pubs {
-        out := strings.Split(r, ":")
-        if out[0] == "PMID" {
-            pr = append(pr, &PubRecord{out[1], "PubMed"})
-            continue
-        }
-        pr = append(pr, &PubRecord{out[1], out[0]})
+    for _, r := range refs {
+        pr = append(pr, &PubRecord{r.Id, r.Pubplace})
     }
     return pr
 }
 
+// Error for a GPAD row that could not be parsed
+type RowError struct {
+    Line   int64
+    Reason string
+}
+
+func (e *RowError) Error() string {
+    return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// A row of temp_gpad staging table
+type GpadRow struct {
+    Digest        string `db:"digest"`
+    Db            string `db:"db"`
+    Id            string `db:"id"`
+    Qualifier     string `db:"qualifier"`
+    Goid          string `db:"goid"`
+    PublicationId string `db:"publication_id"`
+    Pubplace      string `db:"pubplace"`
+    EvidenceCode  string `db:"evidence_code"`
+    AssignedBy    string `db:"assigned_by"`
+    Rank          int    `db:"rank"`
+    DateCurated   string `db:"date_curated"`
+}
+
+// A row of temp_gpad_reference staging table, holds the additional
+// references of a gpad record
+type GpadReference struct {
+    Digest        string `db:"digest"`
+    Pubplace      string `db:"pubplace"`
+    PublicationId string `db:"publication_id"`
+}
+
+// A row of temp_gpad_withfrom staging table
+type GpadWithFrom struct {
+    Digest   string `db:"digest"`
+    Withfrom string `db:"withfrom"`
+}
+
 // Sqlite backend for loading GPAD in staging tables
 type Sqlite struct {
     *gochado.ChadoHelper
@@ -41,90 +94,289 @@ type Sqlite struct {
     sections []string
     // slice holds list of tables
     tables []string
-    // map of buckets for holding rows of data
-    buckets map[string]*gochado.DataBucket
+    // map of buckets for holding rows of data keyed by staging table names
+    buckets map[string]gochado.RowBucket
+    // typed buckets for each of the staging tables that get filled up
+    gpad       *gochado.DataBucket[GpadRow]
+    references *gochado.DataBucket[GpadReference]
+    withfrom   *gochado.DataBucket[GpadWithFrom]
     // map of rank values identify record with different evidence code
     ranks map[string]int
+    // number of rows inserted by a single statement during bulk load
+    batchSize int
+    // transaction for running the statements instead of the database handle
+    tx *sqlx.Tx
+    // version given in the gpa-version header
+    version string
+    // identifier of persistent staging run and its checkpoint
+    run        string
+    checkpoint *gochado.Checkpoint
+    // number of input rows that are added and that are staged by a
+    // previous run with the same identifier
+    offset int64
+    resume int64
+    // receives the progress of loading
+    observer gochado.Observer
+    // parses the references and the ones that could not be parsed
+    normalizer *gochado.ReferenceNormalizer
+    refErrors  gochado.ReferenceErrors
+    // rows that are skipped as they could not be parsed
+    rowErrors []*RowError
+}
+
+// Name of loader that the checkpoints and progress are reported with
+const loaderName = "gpad"
+
+// Sections of the ini file that are required for staging GPAD data
+var RequiredSections = []string{
+    "create_table_temp_gpad",
+    "create_table_temp_gpad_reference",
+    "create_table_temp_gpad_withfrom",
+    "create_table_temp_gpad_new",
 }
 
-func NewStagingSqlite(dbh *sqlx.DB, parser *gochado.SqlParser) *Sqlite {
+// Create new instance of Sqlite structure. The dialect of parser is set from
+// the database handle and the sections are rendered with the default GPAD
+// parameters unless they are set already. Returns error if any of the
+// RequiredSections is absent in the parser.
+func NewStagingSqlite(dbh *sqlx.DB, parser *gochado.SqlParser) (*Sqlite, error) {
+    if len(parser.Dialect()) == 0 {
+        parser.SetDialect(gochado.DialectFor(dbh))
+    }
+    if parser.Params() == nil {
+        if err := parser.SetParams(gochado.DefaultGpadParams()); err != nil {
+            return nil, err
+        }
+    }
+    if err := parser.Require(RequiredSections...); err != nil {
+        return nil, err
+    }
     //list of ini sections
     sec := make([]string, 0)
     tbl := make([]string, 0)
-    //slice of data buckets keyed by staging table names.
-    //each element of bucket slice is map type that represents a row of data.
-    //keys of the map represents column names.
-    buc := make(map[string]*gochado.DataBucket)
+    gpad := gochado.NewDataBucket[GpadRow]()
+    refs := gochado.NewDataBucket[GpadReference]()
+    wfrom := gochado.NewDataBucket[GpadWithFrom]()
+    //data buckets for the known staging tables
+    known := map[string]gochado.RowBucket{
+        "gpad":           gpad,
+        "gpad_new":       gochado.NewDataBucket[GpadRow](),
+        "gpad_reference": refs,
+        "gpad_withfrom":  wfrom,
+    }
+    //data buckets keyed by staging table names.
+    buc := make(map[string]gochado.RowBucket)
     for _, section := range parser.Sections() {
         if strings.HasPrefix(section, "create_table_temp_") {
             n := strings.Replace(section, "create_table_temp_", "", 1)
-            buc[n] = gochado.NewDataBucket()
+            if b, ok := known[n]; ok {
+                buc[n] = b
+            }
             tbl = append(tbl, strings.Replace(section, "create_table_", "", 1))
             sec = append(sec, section)
         }
     }
-    return &Sqlite{gochado.NewChadoHelper(dbh), parser, sec, tbl, buc, make(map[string]int)}
+    return &Sqlite{
+        ChadoHelper: gochado.NewChadoHelper(dbh),
+        sqlparser:   parser,
+        sections:    sec,
+        tables:      tbl,
+        buckets:     buc,
+        gpad:        gpad,
+        references:  refs,
+        withfrom:    wfrom,
+        ranks:       make(map[string]int),
+        batchSize:   DefaultBatchSize,
+        observer:    gochado.NopObserver{},
+        normalizer:  gochado.NewReferenceNormalizer(),
+    }, nil
+}
+
+// Switches to persistent staging tables keyed by a run identifier. The
+// offset of the last staged input row is saved with the checkpoint in every
+// BulkLoad, the data rows up to the saved offset are skipped when a run with
+// the same identifier is repeated. The buckets are cleared once their rows
+// are committed by BulkLoad, they are kept when it runs in an external
+// transaction. It should be called before CreateTables.
+func (sqlite *Sqlite) SetRun(run string, cp *gochado.Checkpoint) error {
+    params, ok := sqlite.sqlparser.Params().(gochado.SqlParams)
+    if !ok {
+        return fmt.Errorf("parameters %T of parser do not support persistent staging", sqlite.sqlparser.Params())
+    }
+    params.RunId = run
+    if _, err := params.StagingTable("gpad"); err != nil {
+        return err
+    }
+    offset, err := cp.Offset(run, loaderName)
+    if err != nil {
+        return err
+    }
+    if err := sqlite.sqlparser.SetParams(params); err != nil {
+        return err
+    }
+    sqlite.run = run
+    sqlite.checkpoint = cp
+    sqlite.resume = offset
+    sqlite.offset = 0
+    return nil
+}
+
+// Returns the number of input rows that are added so far including the
+// skipped ones
+func (sqlite *Sqlite) Offset() int64 {
+    return sqlite.offset
+}
+
+// Name of a staging table
+func (sqlite *Sqlite) table(name string) string {
+    if params, ok := sqlite.sqlparser.Params().(gochado.SqlParams); ok {
+        if tbl, err := params.StagingTable(name); err == nil {
+            return tbl
+        }
+    }
+    return "temp_" + name
+}
+
+// Sets the logger of loader along with its helper and parser, a nil value
+// switches back to the default one
+func (sqlite *Sqlite) SetLogger(l gochado.Logger) {
+    sqlite.ChadoHelper.SetLogger(l)
+    sqlite.sqlparser.SetLogger(l)
+}
+
+// Reports the number of lines read and rows staged in each table along with
+// the time taken by BulkLoad
+func (sqlite *Sqlite) SetObserver(o gochado.Observer) {
+    if o == nil {
+        o = gochado.NopObserver{}
+    }
+    sqlite.observer = o
+}
+
+// Sets the normalizer of references, for example one with a different
+// pubplace mapping
+func (sqlite *Sqlite) SetReferenceNormalizer(n *gochado.ReferenceNormalizer) {
+    sqlite.normalizer = n
+}
+
+// Returns the references that could not be parsed, they are skipped along
+// with the rows that have none of their references parsed
+func (sqlite *Sqlite) ReferenceErrors() gochado.ReferenceErrors {
+    return sqlite.refErrors
+}
+
+// Returns the errors of rows that are skipped as they could not be parsed,
+// for example ones with missing columns or GO and ECO ids without db prefix
+func (sqlite *Sqlite) RowErrors() []*RowError {
+    return sqlite.rowErrors
+}
+
+// Returns the version given in gpa-version header of the added rows
+func (sqlite *Sqlite) SourceVersion() string {
+    return sqlite.version
+}
+
+// Set the number of rows that are inserted by a single statement during bulk
+// load. Values less than one are ignored.
+func (sqlite *Sqlite) SetBatchSize(size int) {
+    if size > 0 {
+        sqlite.batchSize = size
+    }
 }
 
 func (sqlite *Sqlite) AddDataRow(row string) {
+    sqlite.offset++
+    sqlite.observer.LinesRead(loaderName, 1)
+    // ignore comment line other than the version header
+    if strings.HasPrefix(row, "!") {
+        if strings.HasPrefix(row, "!gpa-version:") {
+            sqlite.version = strings.TrimSpace(strings.TrimPrefix(row, "!gpa-version:"))
+        }
+        return
+    }
+    // skip rows that are staged by the previous run
+    if sqlite.offset <= sqlite.resume {
+        return
+    }
     //ignore blank lines
     if br.MatchString(row) {
         return
     }
-    // ignore comment line
-    if strings.HasPrefix(row, "!") {
+    d := strings.Split(row, "\t")
+    if len(d) < gpadColumns {
+        sqlite.skipRow(fmt.Sprintf("expected at least %d columns got %d", gpadColumns, len(d)))
+        return
+    }
+    goid, ok := dbId(d[3])
+    if !ok {
+        sqlite.skipRow(fmt.Sprintf("GO id %q is not of Db:Id form", d[3]))
+        return
+    }
+    evcode, ok := dbId(d[5])
+    if !ok {
+        sqlite.skipRow(fmt.Sprintf("evidence code %q is not of Db:Id form", d[5]))
         return
     }
-    d := strings.Split(row, "\t")
     refs := make([]string, 0)
     if strings.Contains(d[4], "|") {
         refs = append(refs, strings.Split(d[4], "|")...)
     } else {
         refs = append(refs, d[4])
     }
-    goid := strings.Split(d[3], ":")[1]
-    evcode := strings.Split(d[5], ":")[1]
-    pr := NormaLizePubRecord(refs)
-
-    gpad := make(map[string]interface{})
-    gpad["digest"] = gochado.GetMD5Hash(d[1] + d[2] + goid + pr[0].id + pr[0].pubplace + evcode + d[8] + d[9])
-    gpad["id"] = d[1]
-    gpad["qualifier"] = d[2]
-    gpad["goid"] = goid
-    gpad["publication_id"] = pr[0].id
-    gpad["pubplace"] = pr[0].pubplace
-    gpad["evidence_code"] = evcode
-    gpad["date_curated"] = d[8]
-    gpad["assigned_by"] = d[9]
-    rdigest := gochado.GetMD5Hash(d[1] + goid + pr[0].id + pr[0].pubplace)
+    pr, err := sqlite.normalizer.NormalizeAll(refs)
+    if err != nil {
+        var rerrs gochado.ReferenceErrors
+        if !errors.As(err, &rerrs) {
+            gochado.Fatal(sqlite.Logger(), "could not normalize references", "loader", loaderName, "line", sqlite.offset, "error", err)
+        }
+        sqlite.refErrors = append(sqlite.refErrors, rerrs...)
+        sqlite.Logger().Warn("skipped references", "loader", loaderName, "line", sqlite.offset, "error", err)
+    }
+    if len(pr) == 0 {
+        return
+    }
+
+    gpad := GpadRow{
+        Digest:        gochado.GetMD5Hash(d[1] + d[2] + goid + pr[0].Id + pr[0].Pubplace + evcode + d[8] + d[9]),
+        Db:            d[0],
+        Id:            d[1],
+        Qualifier:     d[2],
+        Goid:          goid,
+        PublicationId: pr[0].Id,
+        Pubplace:      pr[0].Pubplace,
+        EvidenceCode:  evcode,
+        DateCurated:   d[8],
+        AssignedBy:    d[9],
+    }
+    rdigest := gochado.GetMD5Hash(d[1] + goid + pr[0].Id + pr[0].Pubplace)
     if r, ok := sqlite.ranks[rdigest]; ok {
         sqlite.ranks[rdigest] = r + 1
-        gpad["rank"] = r + 1
+        gpad.Rank = r + 1
     } else {
         sqlite.ranks[rdigest] = 0
-        gpad["rank"] = 0
+        gpad.Rank = 0
     }
     if _, ok := sqlite.buckets["gpad"]; !ok {
-        log.Fatal("key *gpad* is not found in bucket")
+        gochado.Fatal(sqlite.Logger(), "key is not found in bucket", "loader", loaderName, "key", "gpad", "line", sqlite.offset)
     }
-    sqlite.buckets["gpad"].Push(gpad)
+    sqlite.gpad.Push(gpad)
 
     if len(pr) > 1 {
         if _, ok := sqlite.buckets["gpad_reference"]; !ok {
-            log.Fatal("key *gpad_reference* is not found in bucket")
+            gochado.Fatal(sqlite.Logger(), "key is not found in bucket", "loader", loaderName, "key", "gpad_reference", "line", sqlite.offset)
         }
         for _, r := range pr[1:] {
-            gref := make(map[string]interface{})
-            gref["digest"] = gpad["digest"]
-            gref["publication_id"] = r.id
-            gref["pubplace"] = r.pubplace
-            sqlite.buckets["gpad_reference"].Push(gref)
+            sqlite.references.Push(GpadReference{
+                Digest:        gpad.Digest,
+                PublicationId: r.Id,
+                Pubplace:      r.Pubplace,
+            })
         }
     }
 
     if len(d[6]) > 0 {
         if _, ok := sqlite.buckets["gpad_withfrom"]; !ok {
-            log.Fatal("key *gpad_withfrom* is not found in bucket")
+            gochado.Fatal(sqlite.Logger(), "key is not found in bucket", "loader", loaderName, "key", "gpad_withfrom", "line", sqlite.offset)
         }
         wfrom := make([]string, 0)
         if strings.Contains(d[6], "|") {
@@ -133,66 +385,298 @@ func (sqlite *Sqlite) AddDataRow(row string) {
             wfrom = append(wfrom, d[6])
         }
         for _, value := range wfrom {
-            gwfrom := make(map[string]interface{})
-            gwfrom["digest"] = gpad["digest"]
-            gwfrom["withfrom"] = value
-            sqlite.buckets["gpad_withfrom"].Push(gwfrom)
+            sqlite.withfrom.Push(GpadWithFrom{Digest: gpad.Digest, Withfrom: value})
         }
     }
 }
 
+// Records the error of current row that is skipped
+func (sqlite *Sqlite) skipRow(reason string) {
+    rerr := &RowError{Line: sqlite.offset, Reason: reason}
+    sqlite.rowErrors = append(sqlite.rowErrors, rerr)
+    sqlite.Logger().Warn("skipped row", "loader", loaderName, "line", sqlite.offset, "error", rerr)
+}
+
+// Id part of a Db:Id value
+func dbId(value string) (string, bool) {
+    d := strings.SplitN(value, ":", 2)
+    if len(d) != 2 || len(d[1]) == 0 {
+        return "", false
+    }
+    return d[1], true
+}
+
+// Runs the statements within the transaction instead of the database handle.
+// BulkLoad do not commit the external transaction.
+func (sqlite *Sqlite) SetTx(tx *sqlx.Tx) {
+    sqlite.tx = tx
+}
+
 func (sqlite *Sqlite) CreateTables() {
-    dbh := sqlite.ChadoHelper.ChadoHandler
+    if err := sqlite.CreateTablesContext(context.Background()); err != nil {
+        gochado.Fatal(sqlite.Logger(), "could not create staging tables", "loader", loaderName, "error", err)
+    }
+}
+
+// Same as CreateTables, however aborts on cancellation of the context and
+// returns the error
+func (sqlite *Sqlite) CreateTablesContext(ctx context.Context) error {
     var csec []string
     for _, section := range sqlite.sections {
         csec = append(csec, sqlite.sqlparser.GetSection(section)+";")
     }
-    dbh.Execf(strings.Join(csec, "\n"))
+    if _, err := sqlite.handle().ExecContext(ctx, strings.Join(csec, "\n")); err != nil {
+        return fmt.Errorf("error %s in creating staging tables", err)
+    }
+    if sqlite.resume > 0 {
+        return sqlite.restoreRanks(ctx)
+    }
+    return nil
+}
+
+func (sqlite *Sqlite) handle() gochado.Handle {
+    if sqlite.tx != nil {
+        return sqlite.tx
+    }
+    return sqlite.ChadoHelper.ChadoHandler
+}
+
+// Restores the rank values from the rows staged by the previous run
+func (sqlite *Sqlite) restoreRanks(ctx context.Context) error {
+    var rows []GpadRow
+    err := sqlite.handle().SelectContext(ctx, &rows, sqlite.sqlparser.GetSection("select_temp_gpad_rank"))
+    if err != nil {
+        return fmt.Errorf("error %s in restoring ranks of run %s", err, sqlite.run)
+    }
+    for _, r := range rows {
+        sqlite.ranks[gochado.GetMD5Hash(r.Id+r.Goid+r.PublicationId+r.Pubplace)] = r.Rank
+    }
+    return nil
 }
 
+// Validates the sql statements of staging tables against the database without
+// running them. It should be called before the tables are created.
+func (sqlite *Sqlite) Validate() error {
+    return sqlite.sqlparser.Validate(sqlite.ChadoHelper.ChadoHandler, sqlite.sections...)
+}
+
+// Drops the persistent staging tables along with the checkpoint of run, the
+// temporary tables are left for the database to drop
 func (sqlite *Sqlite) DropTables() {
+    if err := sqlite.DropTablesContext(context.Background()); err != nil {
+        gochado.Fatal(sqlite.Logger(), "could not drop staging tables", "loader", loaderName, "run", sqlite.run, "error", err)
+    }
+}
+
+// Same as DropTables, however aborts on cancellation of the context and
+// returns the error
+func (sqlite *Sqlite) DropTablesContext(ctx context.Context) error {
+    if len(sqlite.run) == 0 {
+        return nil
+    }
+    h := sqlite.handle()
+    for _, name := range sqlite.tables {
+        tbl := sqlite.table(strings.Replace(name, "temp_", "", 1))
+        if _, err := h.ExecContext(ctx, "DROP TABLE IF EXISTS "+tbl); err != nil {
+            return fmt.Errorf("error %s in dropping table %s", err, tbl)
+        }
+    }
+    return sqlite.checkpoint.Remove(ctx, h, sqlite.run, loaderName)
 }
 
 func (sqlite *Sqlite) AlterTables() {
 }
 
 func (sqlite *Sqlite) BulkLoad() {
+    if err := sqlite.BulkLoadContext(context.Background()); err != nil {
+        gochado.Fatal(sqlite.Logger(), "could not load staging tables", "loader", loaderName, "error", err)
+    }
+}
+
+// Same as BulkLoad, however returns the error. The transaction started by
+// the loader is rolled back on error including the cancellation of context,
+// the external one is left for the caller.
+func (sqlite *Sqlite) BulkLoadContext(ctx context.Context) error {
     //Here is how it works...
+    //All staging tables are loaded within a single transaction, either the
+    //external one or a new one
+    start := time.Now()
+    defer func() {
+        sqlite.observer.PhaseDone(loaderName, "staging", time.Since(start))
+    }()
+    tx := sqlite.tx
+    if tx == nil {
+        ntx, err := sqlite.ChadoHelper.ChadoHandler.BeginTxx(ctx, nil)
+        if err != nil {
+            return err
+        }
+        defer ntx.Rollback()
+        tx = ntx
+    }
     //Get name of each staging table
     for name := range sqlite.buckets {
         b := sqlite.buckets[name]
         if b.Count() == 0 { // no data
             continue
         }
-        //Get the first element from bucket and then extract columns names
-        columns := make([]string, 0)
-        for col := range b.GetByPosition(0) {
-            columns = append(columns, col)
+        //Insert the rows in batches, the columns are derived from the row
+        //type of bucket
+        tbl := sqlite.table(name)
+        err := InsertInBatchesContext(ctx, tx, tbl, b, sqlite.batchSize)
+        if err != nil {
+            return fmt.Errorf("error %s in loading table %s", err, tbl)
+        }
+        sqlite.Logger().Info("staged rows", "loader", loaderName, "table", tbl, "rows", b.Count())
+        sqlite.observer.RowsStaged(loaderName, tbl, int64(b.Count()))
+    }
+    if len(sqlite.run) != 0 {
+        err := sqlite.checkpoint.Save(ctx, tx, sqlite.run, loaderName, sqlite.offset)
+        if err != nil {
+            return err
+        }
+    }
+    if sqlite.tx != nil {
+        return nil
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("error %s in commiting staging data", err)
+    }
+    // the staged rows are kept by the checkpoint of run from now on, the
+    // buckets are left intact with an external transaction as the caller
+    // could roll it back
+    if len(sqlite.run) != 0 {
+        sqlite.clearBuckets()
+    }
+    return nil
+}
+
+func (sqlite *Sqlite) clearBuckets() {
+    for _, b := range sqlite.buckets {
+        b.Clear()
+    }
+}
+
+// Inserts rows of a bucket into a table using multi-row INSERT statements
+// with size rows in each of them. The statement for a full batch is prepared
+// once and reused for every batch, the leftover rows are inserted by a
+// separate statement. The batch size gets reduced if it exceeds the limit of
+// bound parameters in a statement.
+func InsertInBatches(h gochado.Handle, tbl string, b gochado.RowBucket, size int) error {
+    return InsertInBatchesContext(context.Background(), h, tbl, b, size)
+}
+
+// Same as InsertInBatches, however aborts on cancellation of the context
+func InsertInBatchesContext(ctx context.Context, h gochado.Handle, tbl string, b gochado.RowBucket, size int) error {
+    columns := b.Columns()
+    count := b.Count()
+    if count == 0 || len(columns) == 0 {
+        return nil
+    }
+    if size < 1 {
+        size = DefaultBatchSize
+    }
+    if size*len(columns) > maxBindParams {
+        size = maxBindParams / len(columns)
+    }
+    if size > count {
+        size = count
+    }
+    stmt, err := h.PreparexContext(ctx, h.Rebind(InsertStatement(tbl, columns, size)))
+    if err != nil {
+        return err
+    }
+    defer stmt.Close()
+
+    var pos int
+    for ; pos+size <= count; pos += size {
+        values, err := rowValues(b, pos, pos+size)
+        if err != nil {
+            return err
+        }
+        if _, err := stmt.ExecContext(ctx, values...); err != nil {
+            return err
         }
-        tbl := "temp_" + name
-        pstmt := fmt.Sprintf("INSERT INTO %s(%s)", tbl, strings.Join(columns, ","))
-        var str bytes.Buffer
-        for _, element := range b.Elements() {
-            fstmt := fmt.Sprintf("%s VALUES(%s);\n", pstmt, strings.Join(ElementToValueString(element, columns), ","))
-            str.WriteString(fstmt)
+    }
+    if pos < count {
+        q := h.Rebind(InsertStatement(tbl, columns, count-pos))
+        values, err := rowValues(b, pos, count)
+        if err != nil {
+            return err
+        }
+        if _, err := h.ExecContext(ctx, q, values...); err != nil {
+            return err
         }
-        sqlite.ChadoHelper.ChadoHandler.Execf(str.String())
     }
+    return nil
 }
 
-func ElementToValueString(element map[string]interface{}, columns []string) []string {
-    values := make([]string, 0)
-    for _, name := range columns {
-        if v, ok := element[name]; ok {
-            switch d := v.(type) {
-            case int:
-                values = append(values, strconv.Itoa(d))
-            case string:
-                values = append(values, "'"+d+"'")
+// Returns an INSERT statement with *count* rows of placeholders in its VALUES
+// clause
+func InsertStatement(tbl string, columns []string, count int) string {
+    ph := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+    values := make([]string, count)
+    for i := range values {
+        values[i] = ph
+    }
+    return fmt.Sprintf(
+        "INSERT INTO %s(%s) VALUES%s",
+        tbl, strings.Join(columns, ","), strings.Join(values, ","),
+    )
+}
+
+// Flattens the values of rows from start to end position of a bucket
+func rowValues(b gochado.RowBucket, start, end int) ([]interface{}, error) {
+    values := make([]interface{}, 0, (end-start)*len(b.Columns()))
+    for pos := start; pos < end; pos++ {
+        for i, v := range b.Values(pos) {
+            bv, err := BindValue(v)
+            if err != nil {
+                return nil, fmt.Errorf("error %s with value of column %s", err, b.Columns()[i])
             }
-        } else {
-            values = append(values, "")
+            values = append(values, bv)
+        }
+    }
+    return values, nil
+}
+
+// Converts a value to one of the types that could be bound to a statement
+// parameter(int64, float64, bool, []byte, string, time.Time or nil). Nil
+// pointers are converted to nil and the rest of them are dereferenced.
+func BindValue(v interface{}) (interface{}, error) {
+    switch d := v.(type) {
+    case nil:
+        return nil, nil
+    case driver.Valuer:
+        rv := reflect.ValueOf(v)
+        if rv.Kind() == reflect.Ptr && rv.IsNil() {
+            return nil, nil
+        }
+        return d.Value()
+    case string, bool, []byte, int64, float64, time.Time:
+        return d, nil
+    case int:
+        return int64(d), nil
+    case int8:
+        return int64(d), nil
+    case int16:
+        return int64(d), nil
+    case int32:
+        return int64(d), nil
+    case uint8:
+        return int64(d), nil
+    case uint16:
+        return int64(d), nil
+    case uint32:
+        return int64(d), nil
+    case float32:
+        return float64(d), nil
+    }
+    rv := reflect.ValueOf(v)
+    if rv.Kind() == reflect.Ptr {
+        if rv.IsNil() {
+            return nil, nil
         }
+        return BindValue(rv.Elem().Interface())
     }
-    return values
+    return nil, fmt.Errorf("unsupported type %T", v)
 }
@@ -2,14 +2,39 @@ package staging
 
 import (
     "bytes"
+    "context"
+    "database/sql"
+    "fmt"
     "github.com/GeertJohan/go.rice"
     "github.com/dictybase/gochado"
     "github.com/dictybase/testchado"
+    "github.com/jmoiron/sqlx"
     . "github.com/onsi/gomega"
+    "log/slog"
     "reflect"
+    "strings"
     "testing"
+    "time"
 )
 
+// Gets a staging loader for the GPAD sql of sqlite
+func newGpadStaging(tb testing.TB, dbh *sqlx.DB) *Sqlite {
+    parser, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        tb.Fatalf("could not get sql for gpad loader error: %s", err)
+    }
+    staging, err := NewStagingSqlite(dbh, parser)
+    if err != nil {
+        tb.Fatalf("could not create staging loader error: %s", err)
+    }
+    return staging
+}
+
+// Rows of test.gpad, each with its line ending
+func gpadLines() []string {
+    return strings.SplitAfter(strings.TrimSpace(rice.MustFindBox("../data").MustString("test.gpad")), "\n")
+}
+
 func TestGpadStagingSqlite(t *testing.T) {
     RegisterTestingT(t)
     chado := testchado.NewSQLiteManager()
@@ -23,16 +48,25 @@ func TestGpadStagingSqlite(t *testing.T) {
     if err != nil {
         t.Errorf("could not open rice box error: %s", err)
     }
-    str, err := r.String("sqlite_gpad.ini")
+    parser, err := gochado.SQLFor("gpad", "sqlite")
+    if err != nil {
+        t.Errorf("could not get sql for gpad loader error:%s", err)
+    }
+    staging, err := NewStagingSqlite(dbh, parser)
     if err != nil {
-        t.Errorf("could not open file sqlite_gpad.ini from rice box error:%s", err)
+        t.Fatalf("could not create staging loader error: %s", err)
     }
-    staging := NewStagingSqlite(dbh, gochado.NewSqlParserFromString(str))
     ln := len(staging.sections)
     if ln != 4 {
         t.Errorf("Expecting 3 entries got %d", ln)
     }
+    if err := staging.Validate(); err != nil {
+        t.Errorf("should have validated the staging sections error: %s", err)
+    }
     staging.CreateTables()
+    if err := staging.Validate(); err == nil {
+        t.Error("should not validate the sections of existing tables")
+    }
     for _, sec := range staging.tables {
         row := dbh.QueryRowx("SELECT name FROM sqlite_temp_master WHERE type = 'table' AND name = $1", sec)
         var tbl string
@@ -161,3 +195,355 @@ func TestGpadStagingSqlite(t *testing.T) {
         t.Errorf("expected %s got %s", "PANTHER:PTN000012953", gw.Withfrom)
     }
 }
+
+func TestGpadStagingSqliteBatchSize(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    chado.DeploySchema()
+    defer chado.DropSchema()
+
+    dbh := chado.DBHandle()
+    staging := newGpadStaging(t, dbh)
+    staging.CreateTables()
+    // batch size that do not divide the number of gpad rows
+    staging.SetBatchSize(3)
+    for _, l := range gpadLines() {
+        staging.AddDataRow(l)
+    }
+    staging.BulkLoad()
+    type entries struct{ Counter int }
+    e := entries{}
+    for tbl, count := range map[string]int{"temp_gpad": 10, "temp_gpad_reference": 1, "temp_gpad_withfrom": 5} {
+        err := dbh.Get(&e, "SELECT COUNT(*) counter FROM "+tbl)
+        if err != nil {
+            t.Errorf("should have executed the query %s", err)
+        }
+        if e.Counter != count {
+            t.Errorf("expected %d got %d in table %s", count, e.Counter, tbl)
+        }
+    }
+}
+
+func BenchmarkGpadStagingSqliteBulkLoad(b *testing.B) {
+    lines := gpadLines()
+    // scale up the gpad file
+    scale := 1000
+    for _, size := range []int{1, 50, DefaultBatchSize} {
+        b.Run(fmt.Sprintf("batch-%d", size), func(b *testing.B) {
+            chado := testchado.NewSQLiteManager()
+            chado.DeploySchema()
+            defer chado.DropSchema()
+            staging := newGpadStaging(b, chado.DBHandle())
+            staging.CreateTables()
+            staging.SetBatchSize(size)
+            for i := 0; i < scale; i++ {
+                for _, line := range lines {
+                    staging.AddDataRow(line)
+                }
+            }
+            var rows int
+            for _, bucket := range staging.buckets {
+                rows += bucket.Count()
+            }
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                staging.BulkLoad()
+            }
+            b.ReportMetric(float64(rows*b.N)/b.Elapsed().Seconds(), "rows/s")
+        })
+    }
+}
+
+func TestGpadStagingSqliteQuotedValues(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    chado.DeploySchema()
+    defer chado.DropSchema()
+
+    dbh := chado.DBHandle()
+    staging := newGpadStaging(t, dbh)
+    staging.CreateTables()
+    wfrom := "UniProtKB:Q54J33'); DROP TABLE temp_gpad; --"
+    staging.AddDataRow(strings.Join([]string{
+        "dictyBase", "DDB_G0272004", "enables", "GO:0001614", "GO_REF:0000033",
+        "ECO:0000318", wfrom, "", "20140221", "O'Reilly", "", "go_evidence=IBA",
+    }, "\t"))
+    staging.BulkLoad()
+
+    type gpad struct {
+        Assigned string `db:"assigned_by"`
+        Withfrom string
+    }
+    g := gpad{}
+    err := dbh.Get(&g, `SELECT temp_gpad.assigned_by, temp_gpad_withfrom.withfrom FROM temp_gpad
+    JOIN temp_gpad_withfrom ON temp_gpad.digest = temp_gpad_withfrom.digest`)
+    if err != nil {
+        t.Fatalf("should have executed the query %s", err)
+    }
+    if g.Assigned != "O'Reilly" {
+        t.Errorf("expected %s got %s", "O'Reilly", g.Assigned)
+    }
+    if g.Withfrom != wfrom {
+        t.Errorf("expected %s got %s", wfrom, g.Withfrom)
+    }
+}
+
+func TestBindValue(t *testing.T) {
+    now := time.Now()
+    str := "it's"
+    var nilstr *string
+    for _, c := range []struct {
+        value    interface{}
+        expected interface{}
+    }{
+        {"it's", "it's"},
+        {10, int64(10)},
+        {int64(20), int64(20)},
+        {float32(1.5), float64(1.5)},
+        {true, true},
+        {now, now},
+        {nil, nil},
+        {&str, "it's"},
+        {nilstr, nil},
+        {sql.NullString{}, nil},
+        {sql.NullInt64{Int64: 5, Valid: true}, int64(5)},
+    } {
+        v, err := BindValue(c.value)
+        if err != nil {
+            t.Errorf("unexpected error %s for value %v", err, c.value)
+        }
+        if !reflect.DeepEqual(v, c.expected) {
+            t.Errorf("expected %v got %v", c.expected, v)
+        }
+    }
+    _, err := BindValue(map[string]int{})
+    if err == nil {
+        t.Error("expected error for unsupported type")
+    }
+}
+
+func TestGpadStagingSqliteResume(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    chado.DeploySchema()
+    defer chado.DropSchema()
+    dbh := chado.DBHandle()
+
+    cp, err := gochado.NewCheckpoint(dbh)
+    if err != nil {
+        t.Fatalf("could not create checkpoint error: %s", err)
+    }
+    if err := cp.CreateTables(); err != nil {
+        t.Fatalf("could not create checkpoint table error: %s", err)
+    }
+    lines := gpadLines()
+    newStaging := func() *Sqlite {
+        staging := newGpadStaging(t, dbh)
+        if err := staging.SetRun("run1", cp); err != nil {
+            t.Fatalf("could not set run error: %s", err)
+        }
+        staging.CreateTables()
+        return staging
+    }
+
+    // first run stages a part of input and fails afterwards, the ranked
+    // duplicate of last staged row comes next
+    first := newStaging()
+    for _, l := range lines[:4] {
+        first.AddDataRow(l)
+    }
+    first.BulkLoad()
+    for _, l := range lines[4:] {
+        first.AddDataRow(l)
+    }
+    offset, err := cp.Offset("run1", "gpad")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if offset != 4 {
+        t.Errorf("expected offset %d got %d", 4, offset)
+    }
+
+    // second run resumes from the checkpoint
+    second := newStaging()
+    for _, l := range lines {
+        second.AddDataRow(l)
+    }
+    if second.gpad.Count() != 7 {
+        t.Errorf("expected %d rows to stage got %d", 7, second.gpad.Count())
+    }
+    // staging in an external transaction that gets rolled back keeps the
+    // buckets
+    tx, err := dbh.Beginx()
+    if err != nil {
+        t.Fatal(err)
+    }
+    second.SetTx(tx)
+    second.BulkLoad()
+    tx.Rollback()
+    second.SetTx(nil)
+    if second.gpad.Count() != 7 {
+        t.Errorf("expected %d rows to stage after rollback got %d", 7, second.gpad.Count())
+    }
+    second.BulkLoad()
+    if second.gpad.Count() != 0 {
+        t.Error("buckets should be cleared after staging")
+    }
+    offset, err = cp.Offset("run1", "gpad")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if offset != int64(len(lines)) {
+        t.Errorf("expected offset %d got %d", len(lines), offset)
+    }
+    var count int
+    for tbl, expected := range map[string]int{
+        "staging_run1_gpad":           10,
+        "staging_run1_gpad_reference": 1,
+        "staging_run1_gpad_withfrom":  5,
+    } {
+        if err := dbh.Get(&count, "SELECT COUNT(*) FROM "+tbl); err != nil {
+            t.Fatal(err)
+        }
+        if count != expected {
+            t.Errorf("expected %d rows in %s got %d", expected, tbl, count)
+        }
+    }
+    // ranks should continue from the first run
+    err = dbh.Get(&count, "SELECT COUNT(*) FROM (SELECT DISTINCT id, goid, publication_id, pubplace, rank FROM staging_run1_gpad)")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if count != 10 {
+        t.Errorf("expected %d distinct ranked rows got %d", 10, count)
+    }
+
+    second.DropTables()
+    if err := dbh.Get(&count, "SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'staging_run1_%'"); err != nil {
+        t.Fatal(err)
+    }
+    if count != 0 {
+        t.Errorf("expected no staging table got %d", count)
+    }
+    offset, err = cp.Offset("run1", "gpad")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if offset != 0 {
+        t.Errorf("expected checkpoint to be removed got offset %d", offset)
+    }
+}
+
+func TestGpadStagingSqliteLogger(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    chado.DeploySchema()
+    defer chado.DropSchema()
+
+    staging := newGpadStaging(t, chado.DBHandle())
+    var b bytes.Buffer
+    staging.SetLogger(slog.New(slog.NewTextHandler(&b, nil)))
+    staging.CreateTables()
+    for _, l := range gpadLines() {
+        staging.AddDataRow(l)
+    }
+    staging.BulkLoad()
+    if !strings.Contains(b.String(), `msg="staged rows" loader=gpad table=temp_gpad rows=10`) {
+        t.Errorf("unexpected log output %s", b.String())
+    }
+}
+
+func TestGpadStagingSqliteContext(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    chado.DeploySchema()
+    defer chado.DropSchema()
+    dbh := chado.DBHandle()
+
+    staging := newGpadStaging(t, dbh)
+    var _ gochado.ContextStagingLoader = staging
+    if err := staging.CreateTablesContext(context.Background()); err != nil {
+        t.Fatalf("should have created tables error: %s", err)
+    }
+    for _, l := range gpadLines() {
+        staging.AddDataRow(l)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := staging.BulkLoadContext(ctx); err == nil {
+        t.Error("should have failed with cancelled context")
+    }
+    var count int
+    if err := dbh.Get(&count, "SELECT COUNT(*) FROM temp_gpad"); err != nil {
+        t.Fatal(err)
+    }
+    if count != 0 {
+        t.Errorf("expected no staged rows got %d", count)
+    }
+    if err := staging.BulkLoadContext(context.Background()); err != nil {
+        t.Fatalf("should have loaded staging tables error: %s", err)
+    }
+    if err := dbh.Get(&count, "SELECT COUNT(*) FROM temp_gpad"); err != nil {
+        t.Fatal(err)
+    }
+    if count != 10 {
+        t.Errorf("expected %d staged rows got %d", 10, count)
+    }
+}
+
+func TestGpadStagingSqliteReferences(t *testing.T) {
+    chado := testchado.NewSQLiteManager()
+    chado.DeploySchema()
+    defer chado.DropSchema()
+
+    dbh := chado.DBHandle()
+    staging := newGpadStaging(t, dbh)
+    n := gochado.NewReferenceNormalizer()
+    n.SetPubplace(gochado.RefGoRef, "GOC")
+    staging.SetReferenceNormalizer(n)
+    staging.CreateTables()
+    for _, c := range [][]string{
+        {"DDB_G0272003", "PMID:15875012|doi:10.1038/NATURE03481|bogus"},
+        {"DDB_G0272004", "GO_REF:33"},
+        {"DDB_G0272005", "nonsense"},
+    } {
+        staging.AddDataRow(strings.Join([]string{
+            "dictyBase", c[0], "enables", "GO:0001614", c[1],
+            "ECO:0000318", "", "", "20140221", "dictyBase", "", "",
+        }, "\t"))
+    }
+    // malformed rows are skipped
+    for _, row := range [][]string{
+        {"dictyBase", "DDB_G0272006", "enables", "0001614", "PMID:15875012", "ECO:0000318", "", "", "20140221", "dictyBase"},
+        {"dictyBase", "DDB_G0272007", "enables", "GO:0001614", "PMID:15875012", "ECO", "", "", "20140221", "dictyBase"},
+        {"dictyBase", "DDB_G0272008", "enables", "GO:0001614"},
+    } {
+        staging.AddDataRow(strings.Join(row, "\t"))
+    }
+    staging.BulkLoad()
+
+    if errs := staging.ReferenceErrors(); len(errs) != 2 {
+        t.Errorf("expected 2 reference errors got %v", errs)
+    }
+    if errs := staging.RowErrors(); len(errs) != 3 || errs[0].Line != 4 {
+        t.Errorf("expected 3 row errors from line 4 got %v", errs)
+    }
+    type pub struct {
+        Id       string `db:"id"`
+        Pubid    string `db:"publication_id"`
+        Pubplace string `db:"pubplace"`
+    }
+    var g []pub
+    if err := dbh.Select(&g, "SELECT id, publication_id, pubplace FROM temp_gpad ORDER BY id"); err != nil {
+        t.Fatal(err)
+    }
+    expected := []pub{
+        {"DDB_G0272003", "15875012", "PubMed"},
+        {"DDB_G0272004", "0000033", "GOC"},
+    }
+    if !reflect.DeepEqual(g, expected) {
+        t.Errorf("expected %v got %v", expected, g)
+    }
+    var r []pub
+    if err := dbh.Select(&r, "SELECT '' id, publication_id, pubplace FROM temp_gpad_reference"); err != nil {
+        t.Fatal(err)
+    }
+    if len(r) != 1 || r[0].Pubid != "10.1038/nature03481" || r[0].Pubplace != "DOI" {
+        t.Errorf("expected the DOI as additional reference got %v", r)
+    }
+}